@@ -0,0 +1,46 @@
+// Command migrate-streams copies room messages from the sorted set message log to the stream
+// message log, see server.MigrateMessagesToStreams. It reads the redis address from REDIS_URL.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/DavidMovas/chat-rooms/internal/server"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	deleteSource := flag.Bool("delete-source", false, "delete sorted sets once their messages are copied, sorted sets with skipped messages are kept")
+	flag.Parse()
+
+	cfg, err := config.NewConfig()
+	failOrError(err, "failed to load config")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisURL,
+	})
+	defer func() {
+		_ = rdb.Close()
+	}()
+
+	report, err := server.MigrateMessagesToStreams(ctx, rdb, *deleteSource)
+	if report != nil {
+		slog.Info("migration finished", "rooms", report.Rooms, "messages", report.Messages, "skipped", report.Skipped)
+	}
+	failOrError(err, "failed to migrate messages")
+}
+
+func failOrError(err error, msg string) {
+	if err != nil {
+		slog.Error(msg, "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,42 @@
+// Command rescore-messages scores messages of the sorted set message log by their numbers,
+// see server.RescoreSortedSetMessages. It reads the redis address from REDIS_URL.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/DavidMovas/chat-rooms/internal/server"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	cfg, err := config.NewConfig()
+	failOrError(err, "failed to load config")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisURL,
+	})
+	defer func() {
+		_ = rdb.Close()
+	}()
+
+	report, err := server.RescoreSortedSetMessages(ctx, rdb)
+	if report != nil {
+		slog.Info("rescore finished", "rooms", report.Rooms, "messages", report.Messages)
+	}
+	failOrError(err, "failed to rescore messages")
+}
+
+func failOrError(err error, msg string) {
+	if err != nil {
+		slog.Error(msg, "error", err)
+		os.Exit(1)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/DavidMovas/chat-rooms/apis/chat"
 	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/DavidMovas/chat-rooms/internal/metrics"
 	"github.com/DavidMovas/chat-rooms/internal/server"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
@@ -25,97 +29,1769 @@ func TestServer(t *testing.T) {
 	prepareInfrastructure(t, runServer)
 }
 
+// TestServerInMemory runs the same scenarios without any external services,
+// replicas share a single in-memory storage.
+func TestServerInMemory(t *testing.T) {
+	storage := server.NewMemoryStorage()
+	newStorage := func(*testing.T) server.Storage {
+		return storage
+	}
+
+	runScenarios(t, testConfig(), newStorage)
+}
+
+// TestServerSQLite runs the same scenarios on an SQLite database, replicas share the storage
+// because SQLite does not deliver events between processes.
+func TestServerSQLite(t *testing.T) {
+	cfg := testConfig()
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "chat.db")
+
+	storage, err := server.NewSQLiteStorage(shortCallCtx(), cfg.SQLitePath)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = storage.Close()
+	})
+
+	newStorage := func(*testing.T) server.Storage {
+		return storage
+	}
+
+	runScenarios(t, cfg, newStorage)
+}
+
 func runServer(t *testing.T, redisConn string) {
-	cfg := &config.Config{
+	cfg := testConfig()
+	cfg.RedisURL = redisConn
+
+	newStorage := func(t *testing.T) server.Storage {
+		return newRedisStorage(t, cfg)
+	}
+
+	runScenarios(t, cfg, newStorage)
+
+	streamCfg := *cfg
+	streamCfg.RedisMessageLog = server.RedisMessageLogStream
+	testStreamMigration(t, cfg, &streamCfg)
+	testRescore(t, cfg)
+
+	require.NoError(t, newRedisClient(t, cfg).FlushAll(shortCallCtx()).Err())
+
+	newStreamStorage := func(t *testing.T) server.Storage {
+		return newRedisStorage(t, &streamCfg)
+	}
+
+	runScenarios(t, &streamCfg, newStreamStorage)
+}
+
+func newRedisClient(t *testing.T, cfg *config.Config) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisURL,
+	})
+
+	if err := rdb.Ping(shortCallCtx()).Err(); err != nil {
+		require.NoError(t, err)
+	}
+
+	t.Cleanup(func() {
+		_ = rdb.Close()
+	})
+
+	return rdb
+}
+
+func newRedisStorage(t *testing.T, cfg *config.Config) *server.RedisStorage {
+	storage, err := server.NewRedisStorage(newRedisClient(t, cfg), cfg)
+	require.NoError(t, err)
+
+	return storage
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
 		Port:     0,
 		Local:    true,
 		LogLevel: "info",
-		RedisURL: redisConn,
+
+		MaxMessages:        500,
+		MaxRetention:       time.Hour,
+		MaxSessionsPerUser: 2,
+		InviteTTL:          time.Hour,
+	}
+}
+
+func runScenarios(t *testing.T, cfg *config.Config, newStorage func(t *testing.T) server.Storage) {
+	port := startServer(t, cfg, newStorage(t))
+	tests(t, port)
+	testMembership(t, fmt.Sprintf("localhost:%d", port))
+	testRoles(t, fmt.Sprintf("localhost:%d", port))
+	testInvites(t, fmt.Sprintf("localhost:%d", port))
+	testEditing(t, fmt.Sprintf("localhost:%d", port))
+	testDeletion(t, fmt.Sprintf("localhost:%d", port))
+	testThreads(t, fmt.Sprintf("localhost:%d", port))
+	testReactions(t, fmt.Sprintf("localhost:%d", port))
+	testReadReceipts(t, fmt.Sprintf("localhost:%d", port))
+
+	replicaPort := startServer(t, cfg, newStorage(t))
+	testReplicas(t, port, replicaPort)
+
+	testHubEviction(t, cfg, newStorage(t))
+	testSlowConsumers(t, cfg, newStorage)
+	testTyping(t, cfg, newStorage(t), newStorage(t))
+	testPresence(t, cfg, newStorage(t), newStorage(t))
+	testAuth(t, cfg, newStorage(t))
+	testRetention(t, cfg, newStorage(t))
+}
+
+func startServer(t *testing.T, cfg *config.Config, storage server.Storage) int {
+	_, port := runTestServer(t, cfg, storage)
+	return port
+}
+
+func runTestServer(t *testing.T, cfg *config.Config, storage server.Storage) (*server.Server, int) {
+	srv, err := server.NewServer(cfg, storage)
+	require.NoError(t, err)
+
+	go func() {
+		if err = srv.Start(); err != nil {
+			require.NoError(t, err)
+		}
+	}()
+
+	var port int
+	retry.Run(t, func(r *retry.R) {
+		port, err = srv.Port()
+		if err != nil {
+			require.NoError(r, err)
+		}
+	})
+
+	return srv, port
+}
+
+func tests(t *testing.T, port int) {
+	t.Logf("starting integration_tests on port %d", port)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+
+	var clients []*RoomClient
+	for i := 0; i < 10; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		clients = append(clients, createClient(t, addr, userID))
+	}
+
+	t.Log("clients created")
+
+	creator := clients[0]
+
+	roomID, err := creator.CreateRoom(shortCallCtx(), "room-1")
+	require.NoError(t, err)
+	t.Logf("room created: %s", roomID)
+
+	testCtx, testCancel := context.WithCancel(context.Background())
+	connections, connectionCtx := errgroup.WithContext(testCtx)
+
+	for _, client := range clients {
+		client := client
+		connections.Go(func() error {
+			return client.Connect(connectionCtx, roomID)
+		})
+	}
+	t.Log("all clients initiated connection")
+
+	for _, client := range clients {
+		client.WaitConnected()
+	}
+	t.Log("all clients connected")
+
+	messagesCount := len(clients) * 100
+	for i := 0; i < messagesCount; i++ {
+		clientN, messageN := i%len(clients), i/len(clients)
+
+		text := fmt.Sprintf("message-%d-%d", messageN, clientN)
+		sendErr := clients[clientN].SendMessage(text)
+		require.NoError(t, sendErr)
+	}
+	t.Log("all clients sent messages")
+
+	time.Sleep(time.Second)
+
+	testCancel()
+	if err = connections.Wait(); !isCancelled(err) {
+		require.NoError(t, err)
+	}
+
+	testRooms(t, clients, roomID, messagesCount)
+	testHistory(t, clients[0], roomID, messagesCount)
+	testUnread(t, addr, roomID, messagesCount)
+	testRoomLifecycle(t, addr)
+	testSessions(t, addr)
+}
+
+func testRooms(t *testing.T, clients []*RoomClient, roomID string, messagesCount int) {
+	creator, other := clients[0], clients[1]
+
+	retry.Run(t, func(r *retry.R) {
+		room, err := creator.GetRoom(shortCallCtx(), roomID)
+		require.NoError(r, err)
+		require.Equal(r, roomID, room.Id)
+		require.Equal(r, creator.UserID(), room.OwnerId)
+		require.Equal(r, int64(messagesCount), room.MessageCount)
+		require.Equal(r, int64(messagesCount-1), room.LastMessageNumber)
+	})
+
+	_, err := creator.GetRoom(shortCallCtx(), "rooms:unknown:data")
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	for _, name := range []string{"general", "games", "music"} {
+		_, err = other.CreateRoom(shortCallCtx(), name)
+		require.NoError(t, err)
+	}
+
+	rooms, _, err := other.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{OwnerId: other.UserID()})
+	require.NoError(t, err)
+	require.Len(t, rooms, 3)
+
+	rooms, _, err = other.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{NamePrefix: "G"})
+	require.NoError(t, err)
+	require.Len(t, rooms, 2)
+	require.Equal(t, "games", rooms[0].Name)
+	require.Equal(t, "general", rooms[1].Name)
+
+	var listed []*chat.Room
+	var pageToken string
+	for {
+		rooms, pageToken, err = other.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{PageSize: 3, PageToken: pageToken})
+		require.NoError(t, err)
+		listed = append(listed, rooms...)
+		if pageToken == "" {
+			break
+		}
+	}
+	require.Len(t, listed, 4)
+}
+
+func testHistory(t *testing.T, client *RoomClient, roomID string, messagesCount int) {
+	last := int64(messagesCount - 1)
+
+	res, err := client.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, Limit: 10})
+	require.NoError(t, err)
+	require.True(t, res.HasMore)
+	requireNumbers(t, res.Messages, last-9, last)
+
+	before := res.Messages[0].Number
+	res, err = client.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, BeforeNumber: &before, Limit: 10})
+	require.NoError(t, err)
+	requireNumbers(t, res.Messages, before-10, before-1)
+
+	after := int64(4)
+	res, err = client.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, AfterNumber: &after, Limit: 5})
+	require.NoError(t, err)
+	require.True(t, res.HasMore)
+	requireNumbers(t, res.Messages, 5, 9)
+
+	after = last - 2
+	res, err = client.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, AfterNumber: &after})
+	require.NoError(t, err)
+	require.False(t, res.HasMore)
+	requireNumbers(t, res.Messages, last-1, last)
+}
+
+func testUnread(t *testing.T, addr, roomID string, messagesCount int) {
+	last := int64(messagesCount - 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recent := createClient(t, addr, "recent-reader")
+	go func() {
+		_ = recent.ConnectFrom(ctx, roomID, last-10)
+	}()
+	recent.WaitConnected()
+
+	stale := createClient(t, addr, "stale-reader")
+	go func() {
+		_ = stale.ConnectFrom(ctx, roomID, 0)
+	}()
+	stale.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, recent.Messages(), 10)
+		require.Len(r, stale.Messages(), 500)
+	})
+
+	requireNumbers(t, recent.Messages(), last-9, last)
+	require.False(t, recent.Gap())
+
+	requireNumbers(t, stale.Messages(), last-499, last)
+	require.True(t, stale.Gap())
+}
+
+// testRescore scores messages by their creation time like sorted sets written before messages were
+// scored by number, rescores them and reads them back.
+func testRescore(t *testing.T, cfg *config.Config) {
+	const messagesCount = 10
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writer := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, cfg, newRedisStorage(t, cfg))), "rescore-writer")
+	roomID, err := writer.CreateRoom(shortCallCtx(), "rescore")
+	require.NoError(t, err)
+
+	go func() {
+		_ = writer.Connect(ctx, roomID)
+	}()
+	writer.WaitConnected()
+
+	for i := 0; i < messagesCount; i++ {
+		require.NoError(t, writer.SendMessage(fmt.Sprintf("message-%d", i)))
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, writer.Messages(), messagesCount)
+	})
+
+	rdb := newRedisClient(t, cfg)
+	key := roomID + ":messages"
+	members, err := rdb.ZRange(ctx, key, 0, -1).Result()
+	require.NoError(t, err)
+
+	// Scores decrease with numbers, so neither ranks nor scores give the right order.
+	for i, member := range members {
+		require.NoError(t, rdb.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().UnixNano() - int64(i)), Member: member}).Err())
+	}
+
+	report, err := server.RescoreSortedSetMessages(ctx, rdb)
+	require.NoError(t, err)
+	require.Equal(t, messagesCount, report.Messages)
+
+	report, err = server.RescoreSortedSetMessages(ctx, rdb)
+	require.NoError(t, err)
+	require.Zero(t, report.Messages)
+
+	reader := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, cfg, newRedisStorage(t, cfg))), "rescore-reader")
+	res, err := reader.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, Limit: messagesCount})
+	require.NoError(t, err)
+	requireNumbers(t, res.Messages, 0, messagesCount-1)
+}
+
+// testStreamMigration writes messages with the sorted set log, migrates them and reads them
+// back with the stream log and a consumer group.
+func testStreamMigration(t *testing.T, cfg, streamCfg *config.Config) {
+	const messagesCount = 20
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writer := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, cfg, newRedisStorage(t, cfg))), "stream-writer")
+	roomID, err := writer.CreateRoom(shortCallCtx(), "streams")
+	require.NoError(t, err)
+
+	go func() {
+		_ = writer.Connect(ctx, roomID)
+	}()
+	writer.WaitConnected()
+
+	for i := 0; i < messagesCount; i++ {
+		require.NoError(t, writer.SendMessage(fmt.Sprintf("message-%d", i)))
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, writer.Messages(), messagesCount)
+	})
+
+	rdb := newRedisClient(t, cfg)
+	report, err := server.MigrateMessagesToStreams(ctx, rdb, false)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, report.Messages, messagesCount)
+
+	report, err = server.MigrateMessagesToStreams(ctx, rdb, false)
+	require.NoError(t, err)
+	require.Zero(t, report.Messages)
+
+	storage := newRedisStorage(t, streamCfg)
+	reader := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, streamCfg, storage)), "stream-reader")
+
+	res, err := reader.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, Limit: messagesCount})
+	require.NoError(t, err)
+	requireNumbers(t, res.Messages, 0, messagesCount-1)
+
+	consumer, err := storage.NewStreamConsumer(ctx, roomID, "processors", "processor-1", -1)
+	require.NoError(t, err)
+
+	messages, err := consumer.Read(ctx, messagesCount*2, -1)
+	require.NoError(t, err)
+	require.Len(t, messages, messagesCount)
+
+	pending, err := consumer.Pending(ctx, messagesCount*2)
+	require.NoError(t, err)
+	require.Len(t, pending, messagesCount)
+
+	numbers := make([]int, len(messages))
+	for i, message := range messages {
+		numbers[i] = message.Number
+	}
+	require.NoError(t, consumer.Ack(ctx, numbers...))
+
+	pending, err = consumer.Pending(ctx, messagesCount*2)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	go func() {
+		_ = reader.Connect(ctx, roomID)
+	}()
+	reader.WaitConnected()
+	require.NoError(t, reader.SendMessage("after migration"))
+
+	messages, err = consumer.Read(ctx, messagesCount, time.Second)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, messagesCount, messages[0].Number)
+
+	// A message sharing the number of another one is skipped, so its sorted set is not deleted.
+	key := roomID + ":messages"
+	duplicate := &server.Message{Number: 0, RoomID: roomID, UserID: writer.UserID(), Text: "duplicate", CreatedAt: time.Now()}
+	require.NoError(t, rdb.ZAdd(ctx, key, redis.Z{Score: 0, Member: duplicate}).Err())
+
+	report, err = server.MigrateMessagesToStreams(ctx, rdb, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Skipped)
+	require.Equal(t, int64(1), rdb.Exists(ctx, key).Val())
+
+	rooms, err := rdb.Keys(ctx, "rooms:*:data:messages").Result()
+	require.NoError(t, err)
+	require.Equal(t, []string{key}, rooms)
+}
+
+// testRetention lowers the room limit with an override and waits for the retention worker to trim it.
+func testRetention(t *testing.T, cfg *config.Config, storage server.Storage) {
+	const messagesCount, maxMessages = 12, 5
+
+	retentionCfg := *cfg
+	retentionCfg.RetentionInterval = 50 * time.Millisecond
+
+	srv, port := runTestServer(t, &retentionCfg, storage)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Stop(ctx)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := createClient(t, fmt.Sprintf("localhost:%d", port), "retention-owner")
+	roomID, err := client.CreateRoom(shortCallCtx(), "retention")
+	require.NoError(t, err)
+
+	limit := int32(maxMessages)
+	res, err := client.client.UpdateRoom(shortCallCtx(), &chat.UpdateRoomRequest{
+		RoomId:      roomID,
+		UserId:      client.UserID(),
+		MaxMessages: &limit,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "retention", res.Room.Name)
+	require.Equal(t, limit, res.Room.MaxMessages)
+
+	go func() {
+		_ = client.Connect(ctx, roomID)
+	}()
+	client.WaitConnected()
+
+	for i := 0; i < messagesCount; i++ {
+		require.NoError(t, client.SendMessage(fmt.Sprintf("message-%d", i)))
 	}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr: cfg.RedisURL,
+	retry.Run(t, func(r *retry.R) {
+		room, err := client.GetRoom(shortCallCtx(), roomID)
+		require.NoError(r, err)
+		require.Equal(r, int64(maxMessages), room.MessageCount)
+		require.Equal(r, int64(messagesCount-1), room.LastMessageNumber)
+	})
+
+	history, err := client.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID})
+	require.NoError(t, err)
+	requireNumbers(t, history.Messages, messagesCount-maxMessages, messagesCount-1)
+
+	// The hub keeps only the messages within the room limits.
+	reader := createClient(t, fmt.Sprintf("localhost:%d", port), "retention-reader")
+	go func() {
+		_ = reader.Connect(ctx, roomID)
+	}()
+	reader.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, reader.Messages(), maxMessages)
+	})
+	requireNumbers(t, reader.Messages(), messagesCount-maxMessages, messagesCount-1)
+
+	stale := createClient(t, fmt.Sprintf("localhost:%d", port), "retention-stale")
+	go func() {
+		_ = stale.ConnectFrom(ctx, roomID, 0)
+	}()
+	stale.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, stale.Messages(), maxMessages)
+	})
+	requireNumbers(t, stale.Messages(), messagesCount-maxMessages, messagesCount-1)
+	require.True(t, stale.Gap())
+
+	// Lowering the limit shrinks the messages kept by the loaded hub.
+	limit = 2
+	_, err = client.client.UpdateRoom(shortCallCtx(), &chat.UpdateRoomRequest{
+		RoomId:      roomID,
+		UserId:      client.UserID(),
+		MaxMessages: &limit,
+	})
+	require.NoError(t, err)
+
+	// The room update reaches the hub asynchronously, so each attempt connects a new client.
+	retry.Run(t, func(r *retry.R) {
+		shrunk := createClient(t, fmt.Sprintf("localhost:%d", port), "retention-shrunk")
+		go func() {
+			_ = shrunk.Connect(ctx, roomID)
+		}()
+		shrunk.WaitConnected()
+
+		var messages []*chat.Message
+		require.Eventually(r, func() bool {
+			messages = shrunk.Messages()
+			return len(messages) > 0
+		}, time.Second, 10*time.Millisecond)
+		require.Len(r, messages, int(limit))
+		require.Equal(r, int64(messagesCount-1), messages[len(messages)-1].Number)
+	})
+
+	// Expired invites are refused and eventually removed by the retention.
+	invite, err := client.client.CreateInvite(shortCallCtx(), &chat.CreateInviteRequest{RoomId: roomID, UserId: client.UserID(), TtlSeconds: 1})
+	require.NoError(t, err)
+
+	attempt := 0
+	retry.Run(t, func(r *retry.R) {
+		attempt++
+		_, err := client.client.JoinWithInvite(shortCallCtx(), &chat.JoinWithInviteRequest{UserId: fmt.Sprintf("retention-invitee-%d", attempt), Code: invite.Invite.Code})
+		require.Equal(r, codes.NotFound, status.Code(err))
+	})
+}
+
+// testMembership checks that only members connect to private rooms and that kicked members are disconnected.
+func testMembership(t *testing.T, addr string) {
+	owner := createClient(t, addr, "membership-owner")
+	bob := createClient(t, addr, "membership-bob")
+	carol := createClient(t, addr, "membership-carol")
+
+	res, err := owner.client.CreateRoom(shortCallCtx(), &chat.CreateRoomRequest{UserId: owner.UserID(), Name: "private", Private: true})
+	require.NoError(t, err)
+	roomID := res.RoomId
+
+	room, err := owner.GetRoom(shortCallCtx(), roomID)
+	require.NoError(t, err)
+	require.True(t, room.Private)
+
+	err = bob.Connect(context.Background(), roomID)
+	require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+
+	_, err = bob.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: roomID, UserId: bob.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = carol.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, UserId: carol.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	joined, err := owner.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: roomID, UserId: owner.UserID(), MemberId: bob.UserID()})
+	require.NoError(t, err)
+	require.Equal(t, bob.UserID(), joined.Member.UserId)
+
+	members, err := bob.client.ListMembers(shortCallCtx(), &chat.ListMembersRequest{RoomId: roomID, UserId: bob.UserID(), PageSize: 1})
+	require.NoError(t, err)
+	require.Len(t, members.Members, 1)
+	require.Equal(t, bob.UserID(), members.Members[0].UserId)
+
+	members, err = bob.client.ListMembers(shortCallCtx(), &chat.ListMembersRequest{RoomId: roomID, UserId: bob.UserID(), PageToken: members.NextPageToken})
+	require.NoError(t, err)
+	require.Len(t, members.Members, 1)
+	require.Equal(t, owner.UserID(), members.Members[0].UserId)
+	require.Empty(t, members.NextPageToken)
+
+	_, err = carol.client.ListMembers(shortCallCtx(), &chat.ListMembersRequest{RoomId: roomID, UserId: carol.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	member := createClient(t, addr, bob.UserID())
+	connected := make(chan error, 1)
+	go func() {
+		connected <- member.Connect(context.Background(), roomID)
+	}()
+	member.WaitConnected()
+
+	require.NoError(t, member.SendMessage("member message"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, member.Messages(), 1)
+	})
+
+	_, err = bob.client.KickMember(shortCallCtx(), &chat.KickMemberRequest{RoomId: roomID, UserId: bob.UserID(), MemberId: owner.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = owner.client.LeaveRoom(shortCallCtx(), &chat.LeaveRoomRequest{RoomId: roomID, UserId: owner.UserID()})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = owner.client.KickMember(shortCallCtx(), &chat.KickMemberRequest{RoomId: roomID, UserId: owner.UserID(), MemberId: bob.UserID()})
+	require.NoError(t, err)
+
+	select {
+	case err = <-connected:
+		require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "kicked member is still connected")
+	}
+
+	publicID, err := owner.CreateRoom(shortCallCtx(), "public")
+	require.NoError(t, err)
+
+	_, err = carol.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: publicID, UserId: carol.UserID()})
+	require.NoError(t, err)
+
+	_, err = carol.client.LeaveRoom(shortCallCtx(), &chat.LeaveRoomRequest{RoomId: publicID, UserId: carol.UserID()})
+	require.NoError(t, err)
+
+	_, err = carol.client.LeaveRoom(shortCallCtx(), &chat.LeaveRoomRequest{RoomId: publicID, UserId: carol.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = carol.GetRoom(shortCallCtx(), roomID)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The private room is skipped without leaving the page short.
+	rooms, pageToken, err := carol.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{OwnerId: owner.UserID(), PageSize: 1})
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	require.Equal(t, publicID, rooms[0].Id)
+	require.Empty(t, pageToken)
+
+	rooms, _, err = owner.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{OwnerId: owner.UserID()})
+	require.NoError(t, err)
+	require.Len(t, rooms, 2)
+
+	// Listing without a user would reveal private rooms.
+	_, err = owner.client.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{OwnerId: owner.UserID()})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Users kicked from a public room are banned, they can not connect or join again on their own.
+	visitor := createClient(t, addr, carol.UserID())
+	go func() {
+		connected <- visitor.Connect(context.Background(), publicID)
+	}()
+	visitor.WaitConnected()
+
+	require.NoError(t, visitor.SendMessage("visitor message"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, visitor.Messages(), 1)
+	})
+
+	_, err = owner.client.KickMember(shortCallCtx(), &chat.KickMemberRequest{RoomId: publicID, UserId: owner.UserID(), MemberId: carol.UserID()})
+	require.NoError(t, err)
+
+	select {
+	case err = <-connected:
+		require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "kicked user is still connected")
+	}
+
+	err = createClient(t, addr, carol.UserID()).Connect(context.Background(), publicID)
+	require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+
+	_, err = carol.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: publicID, UserId: carol.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = owner.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: publicID, UserId: owner.UserID(), MemberId: carol.UserID()})
+	require.NoError(t, err)
+
+	_, err = carol.GetRoom(shortCallCtx(), publicID)
+	require.NoError(t, err)
+}
+
+func testRoles(t *testing.T, addr string) {
+	owner := createClient(t, addr, "roles-owner")
+	admin := createClient(t, addr, "roles-admin")
+	moderator := createClient(t, addr, "roles-moderator")
+	reader := createClient(t, addr, "roles-reader")
+
+	roomID, err := owner.CreateRoom(shortCallCtx(), "roles")
+	require.NoError(t, err)
+
+	for _, c := range []*RoomClient{admin, moderator, reader} {
+		_, err = c.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: roomID, UserId: c.UserID()})
+		require.NoError(t, err)
+	}
+
+	grant := func(granter *RoomClient, memberID string, role chat.Role) error {
+		_, err := granter.client.GrantRole(shortCallCtx(), &chat.GrantRoleRequest{
+			RoomId: roomID, UserId: granter.UserID(), MemberId: memberID, Role: role,
+		})
+		return err
+	}
+
+	require.Equal(t, codes.PermissionDenied, status.Code(grant(moderator, moderator.UserID(), chat.Role_ROLE_ADMIN)))
+	require.NoError(t, grant(owner, admin.UserID(), chat.Role_ROLE_ADMIN))
+	require.NoError(t, grant(admin, moderator.UserID(), chat.Role_ROLE_MODERATOR))
+	require.Equal(t, codes.PermissionDenied, status.Code(grant(admin, moderator.UserID(), chat.Role_ROLE_ADMIN)))
+	require.Equal(t, codes.InvalidArgument, status.Code(grant(owner, admin.UserID(), chat.Role_ROLE_OWNER)))
+
+	_, err = moderator.client.UpdateRoom(shortCallCtx(), &chat.UpdateRoomRequest{RoomId: roomID, UserId: moderator.UserID(), Name: "moderated"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = admin.client.UpdateRoom(shortCallCtx(), &chat.UpdateRoomRequest{RoomId: roomID, UserId: admin.UserID(), Name: "administered"})
+	require.NoError(t, err)
+
+	_, err = admin.client.DeleteRoom(shortCallCtx(), &chat.DeleteRoomRequest{RoomId: roomID, UserId: admin.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- reader.Connect(context.Background(), roomID)
+	}()
+	reader.WaitConnected()
+
+	require.NoError(t, reader.SendMessage("before read-only"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, reader.Messages(), 1)
+	})
+
+	require.NoError(t, grant(admin, reader.UserID(), chat.Role_ROLE_READ_ONLY))
+
+	// The role change reaches the connection asynchronously, posting fails once it is applied.
+	retry.Run(t, func(r *retry.R) {
+		_ = reader.SendMessage("after read-only")
+
+		select {
+		case err = <-connected:
+			require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+		case <-time.After(100 * time.Millisecond):
+			require.Fail(r, "read-only member can still post")
+		}
+	})
+
+	_, err = moderator.client.KickMember(shortCallCtx(), &chat.KickMemberRequest{RoomId: roomID, UserId: moderator.UserID(), MemberId: admin.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	revoked, err := owner.client.RevokeRole(shortCallCtx(), &chat.RevokeRoleRequest{RoomId: roomID, UserId: owner.UserID(), MemberId: admin.UserID()})
+	require.NoError(t, err)
+	require.Equal(t, chat.Role_ROLE_MEMBER, revoked.Member.Role)
+
+	_, err = moderator.client.KickMember(shortCallCtx(), &chat.KickMemberRequest{RoomId: roomID, UserId: moderator.UserID(), MemberId: admin.UserID()})
+	require.NoError(t, err)
+
+	members, err := owner.client.ListMembers(shortCallCtx(), &chat.ListMembersRequest{RoomId: roomID, UserId: owner.UserID()})
+	require.NoError(t, err)
+
+	roles := make(map[string]chat.Role)
+	for _, m := range members.Members {
+		roles[m.UserId] = m.Role
+	}
+	require.Equal(t, map[string]chat.Role{
+		owner.UserID():     chat.Role_ROLE_OWNER,
+		moderator.UserID(): chat.Role_ROLE_MODERATOR,
+		reader.UserID():    chat.Role_ROLE_READ_ONLY,
+	}, roles)
+
+	// The read-only restriction outlives the membership of the public room.
+	_, err = reader.client.LeaveRoom(shortCallCtx(), &chat.LeaveRoomRequest{RoomId: roomID, UserId: reader.UserID()})
+	require.NoError(t, err)
+
+	reconnected := createClient(t, addr, reader.UserID())
+	go func() {
+		connected <- reconnected.Connect(context.Background(), roomID)
+	}()
+	reconnected.WaitConnected()
+
+	_ = reconnected.SendMessage("after leaving")
+	select {
+	case err = <-connected:
+		require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "read-only user can post after leaving")
+	}
+
+	joined, err := reader.client.JoinRoom(shortCallCtx(), &chat.JoinRoomRequest{RoomId: roomID, UserId: reader.UserID()})
+	require.NoError(t, err)
+	require.Equal(t, chat.Role_ROLE_READ_ONLY, joined.Member.Role)
+}
+
+func testInvites(t *testing.T, addr string) {
+	owner := createClient(t, addr, "invites-owner")
+	bob := createClient(t, addr, "invites-bob")
+	carol := createClient(t, addr, "invites-carol")
+
+	res, err := owner.client.CreateRoom(shortCallCtx(), &chat.CreateRoomRequest{UserId: owner.UserID(), Name: "invites", Private: true})
+	require.NoError(t, err)
+	roomID := res.RoomId
+
+	createInvite := func(ttlSeconds int64, maxUses int32) (*chat.Invite, error) {
+		res, err := owner.client.CreateInvite(shortCallCtx(), &chat.CreateInviteRequest{
+			RoomId: roomID, UserId: owner.UserID(), TtlSeconds: ttlSeconds, MaxUses: maxUses,
+		})
+		return res.GetInvite(), err
+	}
+
+	joinWithInvite := func(c *RoomClient, code string) error {
+		_, err := c.client.JoinWithInvite(shortCallCtx(), &chat.JoinWithInviteRequest{UserId: c.UserID(), Code: code})
+		return err
+	}
+
+	_, err = bob.client.CreateInvite(shortCallCtx(), &chat.CreateInviteRequest{RoomId: roomID, UserId: bob.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	invite, err := createInvite(0, 1)
+	require.NoError(t, err)
+	require.True(t, invite.ExpiresAt.AsTime().After(time.Now()))
+
+	require.NoError(t, joinWithInvite(bob, invite.Code))
+	require.NoError(t, joinWithInvite(bob, invite.Code))
+	require.Equal(t, codes.FailedPrecondition, status.Code(joinWithInvite(carol, invite.Code)))
+
+	_, err = bob.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, UserId: bob.UserID()})
+	require.NoError(t, err)
+
+	revoked, err := createInvite(0, 0)
+	require.NoError(t, err)
+
+	_, err = bob.client.RevokeInvite(shortCallCtx(), &chat.RevokeInviteRequest{UserId: bob.UserID(), Code: revoked.Code})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = owner.client.RevokeInvite(shortCallCtx(), &chat.RevokeInviteRequest{UserId: owner.UserID(), Code: revoked.Code})
+	require.NoError(t, err)
+	require.Equal(t, codes.NotFound, status.Code(joinWithInvite(carol, revoked.Code)))
+
+	expiring, err := createInvite(1, 0)
+	require.NoError(t, err)
+	time.Sleep(time.Until(expiring.ExpiresAt.AsTime()))
+	require.Equal(t, codes.FailedPrecondition, status.Code(joinWithInvite(carol, expiring.Code)))
+
+	_, err = bob.client.ListInviteUses(shortCallCtx(), &chat.ListInviteUsesRequest{RoomId: roomID, UserId: bob.UserID()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	uses, err := owner.client.ListInviteUses(shortCallCtx(), &chat.ListInviteUsesRequest{RoomId: roomID, UserId: owner.UserID()})
+	require.NoError(t, err)
+	require.Len(t, uses.Uses, 1)
+	require.Equal(t, invite.Code, uses.Uses[0].Code)
+	require.Equal(t, bob.UserID(), uses.Uses[0].UserId)
+	require.Equal(t, owner.UserID(), uses.Uses[0].CreatedBy)
+	require.Empty(t, uses.NextPageToken)
+
+	// Concurrent joins through the same invite all succeed and are all counted.
+	shared, err := createInvite(0, 0)
+	require.NoError(t, err)
+
+	joiners := make([]*RoomClient, 10)
+	for i := range joiners {
+		joiners[i] = createClient(t, addr, fmt.Sprintf("invites-joiner-%d", i))
+	}
+
+	var joins errgroup.Group
+	for _, joiner := range joiners {
+		joiner := joiner
+		joins.Go(func() error {
+			return joinWithInvite(joiner, shared.Code)
+		})
+	}
+	require.NoError(t, joins.Wait())
+
+	uses, err = owner.client.ListInviteUses(shortCallCtx(), &chat.ListInviteUsesRequest{RoomId: roomID, UserId: owner.UserID()})
+	require.NoError(t, err)
+	require.Len(t, uses.Uses, 1+len(joiners))
+}
+
+func testEditing(t *testing.T, addr string) {
+	author := createClient(t, addr, "editing-author")
+	reader := createClient(t, addr, "editing-reader")
+
+	roomID, err := author.CreateRoom(shortCallCtx(), "editing")
+	require.NoError(t, err)
+
+	for _, c := range []*RoomClient{author, reader} {
+		go func() {
+			_ = c.Connect(context.Background(), roomID)
+		}()
+		c.WaitConnected()
+	}
+
+	require.NoError(t, author.SendMessage("original"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, reader.Messages(), 1)
+	})
+	number := reader.Messages()[0].Number
+
+	editMessage := func(c *RoomClient, text string) (*chat.Message, error) {
+		res, err := c.client.EditMessage(shortCallCtx(), &chat.EditMessageRequest{
+			RoomId: roomID, UserId: c.UserID(), Number: number, Text: text,
+		})
+		return res.GetMessage(), err
+	}
+
+	_, err = editMessage(reader, "hijacked")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = author.client.EditMessage(shortCallCtx(), &chat.EditMessageRequest{RoomId: roomID, UserId: author.UserID(), Number: number + 1, Text: "missing"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = editMessage(author, "first edit")
+	require.NoError(t, err)
+
+	edited, err := editMessage(author, "second edit")
+	require.NoError(t, err)
+	require.NotNil(t, edited.EditedAt)
+
+	retry.Run(t, func(r *retry.R) {
+		messages := reader.Messages()
+		require.Len(r, messages, 1)
+		require.Equal(r, "second edit", messages[0].Text)
+		require.NotNil(r, messages[0].EditedAt)
+	})
+
+	history, err := reader.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, UserId: reader.UserID()})
+	require.NoError(t, err)
+	require.Len(t, history.Messages, 1)
+	require.Equal(t, "second edit", history.Messages[0].Text)
+
+	revisions, err := reader.client.GetMessageRevisions(shortCallCtx(), &chat.GetMessageRevisionsRequest{RoomId: roomID, UserId: reader.UserID(), Number: number})
+	require.NoError(t, err)
+	require.Len(t, revisions.Revisions, 2)
+	require.Equal(t, "original", revisions.Revisions[0].Text)
+	require.Equal(t, "first edit", revisions.Revisions[1].Text)
+
+	late := createClient(t, addr, "editing-late")
+	go func() {
+		_ = late.Connect(context.Background(), roomID)
+	}()
+	late.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		messages := late.Messages()
+		require.Len(r, messages, 1)
+		require.Equal(r, "second edit", messages[0].Text)
+	})
+}
+
+// testDeletion checks that authors and moderators replace messages with tombstones keeping their numbers.
+func testDeletion(t *testing.T, addr string) {
+	owner := createClient(t, addr, "deletion-owner")
+	author := createClient(t, addr, "deletion-author")
+	bystander := createClient(t, addr, "deletion-bystander")
+
+	roomID, err := owner.CreateRoom(shortCallCtx(), "deletion")
+	require.NoError(t, err)
+
+	for _, c := range []*RoomClient{owner, author} {
+		go func() {
+			_ = c.Connect(context.Background(), roomID)
+		}()
+		c.WaitConnected()
+	}
+
+	for _, text := range []string{"mistake", "abuse", "fine"} {
+		require.NoError(t, author.SendMessage(text))
+	}
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, owner.Messages(), 3)
+	})
+	messages := owner.Messages()
+
+	deleteMessage := func(c *RoomClient, number int64) (*chat.Message, error) {
+		res, err := c.client.DeleteMessage(shortCallCtx(), &chat.DeleteMessageRequest{
+			RoomId: roomID, UserId: c.UserID(), Number: number,
+		})
+		return res.GetMessage(), err
+	}
+
+	_, err = deleteMessage(bystander, messages[0].Number)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = author.client.EditMessage(shortCallCtx(), &chat.EditMessageRequest{
+		RoomId: roomID, UserId: author.UserID(), Number: messages[0].Number, Text: "edited mistake",
+	})
+	require.NoError(t, err)
+
+	deleted, err := deleteMessage(author, messages[0].Number)
+	require.NoError(t, err)
+	require.Empty(t, deleted.Text)
+	require.NotNil(t, deleted.DeletedAt)
+	require.Equal(t, author.UserID(), deleted.DeletedBy)
+
+	_, err = deleteMessage(owner, messages[1].Number)
+	require.NoError(t, err)
+
+	_, err = deleteMessage(owner, messages[1].Number)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = author.client.EditMessage(shortCallCtx(), &chat.EditMessageRequest{
+		RoomId: roomID, UserId: author.UserID(), Number: messages[1].Number, Text: "abuse again",
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	requireTombstones := func(r require.TestingT, messages []*chat.Message) {
+		require.Len(r, messages, 3)
+		require.Empty(r, messages[0].Text)
+		require.Equal(r, author.UserID(), messages[0].DeletedBy)
+		require.Empty(r, messages[1].Text)
+		require.Equal(r, owner.UserID(), messages[1].DeletedBy)
+		require.Equal(r, "fine", messages[2].Text)
+		require.Nil(r, messages[2].DeletedAt)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		requireTombstones(r, author.Messages())
+	})
+
+	history, err := bystander.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, UserId: bystander.UserID()})
+	require.NoError(t, err)
+	requireTombstones(t, history.Messages)
+
+	revisions, err := bystander.client.GetMessageRevisions(shortCallCtx(), &chat.GetMessageRevisionsRequest{
+		RoomId: roomID, UserId: bystander.UserID(), Number: messages[0].Number,
+	})
+	require.NoError(t, err)
+	require.Empty(t, revisions.Revisions)
+
+	go func() {
+		_ = bystander.Connect(context.Background(), roomID)
+	}()
+	bystander.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		requireTombstones(r, bystander.Messages())
+	})
+}
+
+// testThreads checks that replies join the thread of their parent and thread updates are delivered.
+func testThreads(t *testing.T, addr string) {
+	author := createClient(t, addr, "threads-author")
+	replier := createClient(t, addr, "threads-replier")
+
+	roomID, err := author.CreateRoom(shortCallCtx(), "threads")
+	require.NoError(t, err)
+
+	for _, c := range []*RoomClient{author, replier} {
+		go func() {
+			_ = c.Connect(context.Background(), roomID)
+		}()
+		c.WaitConnected()
+	}
+
+	require.NoError(t, author.SendMessage("root"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, replier.Messages(), 1)
+	})
+	root := replier.Messages()[0].Number
+
+	require.NoError(t, replier.SendReply("first reply", root))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, author.Messages(), 2)
+	})
+	reply := author.Messages()[1]
+	require.Equal(t, root, reply.GetReplyToNumber())
+
+	// A reply to a reply joins the thread of its parent, messages in between stay out of the thread.
+	require.NoError(t, author.SendMessage("unrelated"))
+	require.NoError(t, author.SendReply("second reply", reply.Number))
+
+	retry.Run(t, func(r *retry.R) {
+		messages := replier.Messages()
+		require.Len(r, messages, 4)
+		require.Nil(r, messages[2].ReplyToNumber)
+		require.Equal(r, root, messages[3].GetReplyToNumber())
+		require.Equal(r, int32(2), replier.ReplyCount(root))
+	})
+
+	history, err := author.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, UserId: author.UserID()})
+	require.NoError(t, err)
+	require.Len(t, history.Messages, 4)
+	require.Equal(t, int32(2), history.Messages[0].ReplyCount)
+	require.Zero(t, history.Messages[1].ReplyCount)
+
+	thread, err := author.client.GetThread(shortCallCtx(), &chat.GetThreadRequest{RoomId: roomID, UserId: author.UserID(), Number: root, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, "root", thread.Root.Text)
+	require.Equal(t, int32(2), thread.Root.ReplyCount)
+	require.Len(t, thread.Replies, 1)
+	require.Equal(t, "first reply", thread.Replies[0].Text)
+	require.True(t, thread.HasMore)
+
+	thread, err = author.client.GetThread(shortCallCtx(), &chat.GetThreadRequest{
+		RoomId: roomID, UserId: author.UserID(), Number: root, AfterNumber: &thread.Replies[0].Number,
+	})
+	require.NoError(t, err)
+	require.Len(t, thread.Replies, 1)
+	require.Equal(t, "second reply", thread.Replies[0].Text)
+	require.False(t, thread.HasMore)
+
+	late := createClient(t, addr, "threads-late")
+	go func() {
+		_ = late.Connect(context.Background(), roomID)
+	}()
+	late.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		messages := late.Messages()
+		require.Len(r, messages, 4)
+		require.Equal(r, int32(2), messages[0].ReplyCount)
+	})
+
+	lost := createClient(t, addr, "threads-lost")
+	connected := make(chan error, 1)
+	go func() {
+		connected <- lost.Connect(context.Background(), roomID)
+	}()
+	lost.WaitConnected()
+
+	require.NoError(t, lost.SendReply("lost", root+100))
+	require.Equal(t, codes.NotFound, status.Code(<-connected))
+}
+
+// testReactions checks that reactions are aggregated per message and their changes are broadcast.
+func testReactions(t *testing.T, addr string) {
+	author := createClient(t, addr, "reactions-author")
+	fan := createClient(t, addr, "reactions-fan")
+
+	roomID, err := author.CreateRoom(shortCallCtx(), "reactions")
+	require.NoError(t, err)
+
+	go func() {
+		_ = author.Connect(context.Background(), roomID)
+	}()
+	author.WaitConnected()
+
+	require.NoError(t, author.SendMessage("react to me"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, author.Messages(), 1)
+	})
+	number := author.Messages()[0].Number
+
+	react := func(c *RoomClient, emoji string) error {
+		_, err := c.client.AddReaction(shortCallCtx(), &chat.AddReactionRequest{
+			RoomId: roomID, UserId: c.UserID(), Number: number, Emoji: emoji,
+		})
+		return err
+	}
+
+	require.NoError(t, react(author, "👍"))
+	require.NoError(t, react(fan, "👍"))
+	require.NoError(t, react(fan, "👍"))
+	require.NoError(t, react(fan, "🎉"))
+
+	_, err = fan.client.RemoveReaction(shortCallCtx(), &chat.RemoveReactionRequest{RoomId: roomID, UserId: fan.UserID(), Number: number, Emoji: "🎉"})
+	require.NoError(t, err)
+
+	require.Equal(t, codes.InvalidArgument, status.Code(react(fan, "")))
+
+	_, err = fan.client.AddReaction(shortCallCtx(), &chat.AddReactionRequest{RoomId: roomID, UserId: fan.UserID(), Number: number + 1, Emoji: "👍"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	retry.Run(t, func(r *retry.R) {
+		updates := author.ReactionUpdates()
+		require.Len(r, updates, 4)
+		require.Equal(r, int32(2), updates[1].Count)
+		require.Equal(r, fan.UserID(), updates[1].UserId)
+		require.True(r, updates[2].Added)
+		require.False(r, updates[3].Added)
+		require.Zero(r, updates[3].Count)
+	})
+
+	requireReactions := func(r require.TestingT, message *chat.Message) {
+		require.Len(r, message.Reactions, 1)
+		require.Equal(r, "👍", message.Reactions[0].Emoji)
+		require.Equal(r, int32(2), message.Reactions[0].Count)
+		require.Equal(r, []string{author.UserID(), fan.UserID()}, message.Reactions[0].UserIds)
+	}
+
+	history, err := fan.client.GetHistory(shortCallCtx(), &chat.GetHistoryRequest{RoomId: roomID, UserId: fan.UserID()})
+	require.NoError(t, err)
+	require.Len(t, history.Messages, 1)
+	requireReactions(t, history.Messages[0])
+
+	go func() {
+		_ = fan.Connect(context.Background(), roomID)
+	}()
+	fan.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		messages := fan.Messages()
+		require.Len(r, messages, 1)
+		requireReactions(r, messages[0])
+	})
+
+	_, err = author.client.DeleteMessage(shortCallCtx(), &chat.DeleteMessageRequest{RoomId: roomID, UserId: author.UserID(), Number: number})
+	require.NoError(t, err)
+	require.Equal(t, codes.FailedPrecondition, status.Code(react(fan, "👍")))
+
+	retry.Run(t, func(r *retry.R) {
+		messages := fan.Messages()
+		require.Len(r, messages, 1)
+		require.Empty(r, messages[0].Reactions)
+	})
+}
+
+// testReadReceipts checks that read cursors only move forward, are broadcast to the room and are used
+// when a user connects without the last read message number.
+func testReadReceipts(t *testing.T, addr string) {
+	reader := createClient(t, addr, "receipts-reader")
+	watcher := createClient(t, addr, "receipts-watcher")
+
+	roomID, err := reader.CreateRoom(shortCallCtx(), "receipts")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = reader.Connect(ctx, roomID)
+	}()
+	reader.WaitConnected()
+
+	for i := range 3 {
+		require.NoError(t, reader.SendMessage(fmt.Sprintf("receipt-%d", i)))
+	}
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, reader.Messages(), 3)
+	})
+	messages := reader.Messages()
+
+	go func() {
+		_ = watcher.Connect(context.Background(), roomID)
+	}()
+	watcher.WaitConnected()
+
+	// The watcher has joined the room once it receives the messages.
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, watcher.Messages(), 3)
+	})
+
+	// Cursors never move back and numbers beyond the last message are capped to it.
+	require.NoError(t, reader.MarkRead(messages[1].Number))
+	require.NoError(t, reader.MarkRead(messages[0].Number))
+	require.NoError(t, reader.MarkRead(messages[2].Number+100))
+
+	requireReceipts := func(r require.TestingT, receipts []*chat.ReadReceipt, numbers ...int64) {
+		require.Len(r, receipts, len(numbers))
+		for i, receipt := range receipts {
+			require.Equal(r, reader.UserID(), receipt.UserId)
+			require.Equal(r, numbers[i], receipt.Number)
+		}
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		requireReceipts(r, watcher.ReadReceipts(), messages[1].Number, messages[2].Number)
+		requireReceipts(r, reader.ReadReceipts(), messages[1].Number, messages[2].Number)
+	})
+
+	receipts, err := watcher.GetReadReceipts(shortCallCtx(), roomID)
+	require.NoError(t, err)
+	requireReceipts(t, receipts, messages[2].Number)
+
+	require.NoError(t, reader.SendMessage("after the cursor"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, watcher.Messages(), 4)
+	})
+	cancel()
+
+	reconnected := createClient(t, addr, reader.UserID())
+	go func() {
+		_ = reconnected.ConnectFromReadCursor(context.Background(), roomID)
+	}()
+	reconnected.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		unread := reconnected.Messages()
+		require.Len(r, unread, 1)
+		require.Equal(r, "after the cursor", unread[0].Text)
+	})
+	require.False(t, reconnected.Gap())
+}
+
+// testHubEviction waits for the hub of a room left by its users to be evicted and reconnects to it.
+func testHubEviction(t *testing.T, cfg *config.Config, storage server.Storage) {
+	evictionCfg := *cfg
+	evictionCfg.HubIdleTTL = 100 * time.Millisecond
+
+	srv, port := runTestServer(t, &evictionCfg, storage)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	client := createClient(t, addr, "eviction-owner")
+	roomID, err := client.CreateRoom(shortCallCtx(), "eviction")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = client.Connect(ctx, roomID)
+	}()
+	client.WaitConnected()
+
+	require.NoError(t, client.SendMessage("before eviction"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, client.Messages(), 1)
+	})
+	require.Equal(t, 1, srv.LoadedRoomHubs())
+
+	cancel()
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, 0, srv.LoadedRoomHubs())
+	})
+
+	reconnected := createClient(t, addr, "eviction-owner")
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = reconnected.Connect(ctx, roomID)
+	}()
+	reconnected.WaitConnected()
+
+	require.NoError(t, reconnected.SendMessage("after eviction"))
+	retry.Run(t, func(r *retry.R) {
+		messages := reconnected.Messages()
+		require.Len(r, messages, 2)
+		require.Equal(r, "before eviction", messages[0].Text)
+		require.Equal(r, "after eviction", messages[1].Text)
+	})
+	require.Equal(t, 1, srv.LoadedRoomHubs())
+}
+
+// testSlowConsumers checks each slow consumer policy with a connection that stops reading its stream.
+func testSlowConsumers(t *testing.T, cfg *config.Config, newStorage func(t *testing.T) server.Storage) {
+	for _, policy := range []server.SlowConsumerPolicy{server.SlowConsumerDropOldest, server.SlowConsumerDisconnect, server.SlowConsumerBlock} {
+		policyCfg := *cfg
+		policyCfg.SlowConsumerPolicy = string(policy)
+		policyCfg.SlowConsumerTimeout = 100 * time.Millisecond
+		policyCfg.ConnectionBufferSize = 2
+
+		addr := fmt.Sprintf("localhost:%d", startServer(t, &policyCfg, newStorage(t)))
+		writer := createClient(t, addr, "slow-writer")
+		roomID, err := writer.CreateRoom(shortCallCtx(), "slow-"+string(policy))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			_ = writer.Connect(ctx, roomID)
+		}()
+		writer.WaitConnected()
+
+		stalled := connectStalled(t, addr, "slow-reader", roomID)
+		retry.Run(t, func(r *retry.R) {
+			require.True(r, hasPresence(writer.PresenceUpdates(), "slow-reader", true))
+		})
+
+		action := map[server.SlowConsumerPolicy]string{
+			server.SlowConsumerDropOldest: "drop_oldest",
+			server.SlowConsumerDisconnect: "disconnect",
+			server.SlowConsumerBlock:      "block_timeout",
+		}[policy]
+		fired := slowConsumerCount(action)
+
+		// Large messages fill the stream flow control window, so the server stops sending to the reader.
+		const messagesCount = 50
+		text := strings.Repeat("x", 32<<10)
+		for i := range messagesCount {
+			require.NoError(t, writer.SendMessage(text))
+
+			// The writer waits for its own message, so only the stalled connection falls behind.
+			require.Eventually(t, func() bool {
+				return len(writer.Messages()) == i+1
+			}, 5*time.Second, time.Millisecond)
+		}
+
+		retry.Run(t, func(r *retry.R) {
+			require.Greater(r, slowConsumerCount(action), fired)
+		})
+
+		if policy == server.SlowConsumerDropOldest {
+			requireGap(t, stalled)
+		} else {
+			requireDisconnected(t, stalled, codes.ResourceExhausted)
+		}
+
+		cancel()
+	}
+}
+
+// connectStalled connects to the room with a stream that is never read.
+func connectStalled(t *testing.T, addr, userID, roomID string) chat.ChatService_ConnectClient {
+	// Fixed windows disable the dynamic window growth, which would buffer the whole room on the client.
+	c := createClient(t, addr, userID, grpc.WithInitialWindowSize(64<<10), grpc.WithInitialConnWindowSize(64<<10))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	stream, err := c.client.Connect(ctx)
+	require.NoError(t, err)
+
+	lastRead := int64(-1)
+	require.NoError(t, stream.Send(&chat.ConnectRequest{
+		Payload: &chat.ConnectRequest_ConnectRoom_{
+			ConnectRoom: &chat.ConnectRequest_ConnectRoom{UserId: userID, RoomId: roomID, LastReadMessageNumber: &lastRead},
+		},
+	}))
+
+	return stream
+}
+
+// requireGap reads the stream until the notice about dropped events.
+func requireGap(t *testing.T, stream chat.ChatService_ConnectClient) {
+	for {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+
+		if gap, ok := res.Payload.(*chat.ConnectResponse_Gap); ok {
+			require.Positive(t, gap.Gap.DroppedEvents)
+			return
+		}
+	}
+}
+
+// requireDisconnected reads the stream until the server closes it.
+func requireDisconnected(t *testing.T, stream chat.ChatService_ConnectClient, code codes.Code) {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			require.Equal(t, code, status.Code(err), err)
+			return
+		}
+	}
+}
+
+func slowConsumerCount(action string) int64 {
+	if count, ok := metrics.SlowConsumer.Get(action).(interface{ Value() int64 }); ok {
+		return count.Value()
+	}
+
+	return 0
+}
+
+// testTyping checks that typing indicators are throttled, expire and stop once the user sends a message,
+// and that they reach users connected through another replica.
+func testTyping(t *testing.T, cfg *config.Config, storage, replicaStorage server.Storage) {
+	typingCfg := *cfg
+	typingCfg.TypingTimeout = 200 * time.Millisecond
+	typingCfg.TypingThrottle = time.Hour
+
+	writer := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, &typingCfg, storage)), "typing-writer")
+	watcher := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, &typingCfg, replicaStorage)), "typing-watcher")
+
+	roomID, err := writer.CreateRoom(shortCallCtx(), "typing")
+	require.NoError(t, err)
+
+	for _, c := range []*RoomClient{writer, watcher} {
+		go func() {
+			_ = c.Connect(context.Background(), roomID)
+		}()
+		c.WaitConnected()
+	}
+
+	// The watcher has joined the room once it receives a message.
+	require.NoError(t, writer.SendMessage("hello"))
+	retry.Run(t, func(r *retry.R) {
+		require.Len(r, watcher.Messages(), 1)
+	})
+
+	requireTyping := func(r require.TestingT, updates []*chat.TypingUpdate, typing ...bool) {
+		require.Len(r, updates, len(typing))
+		for i, update := range updates {
+			require.Equal(r, writer.UserID(), update.UserId)
+			require.Equal(r, typing[i], update.Typing)
+		}
+	}
+
+	// Repeated signals within the throttle are not broadcast, the indicator expires without them.
+	require.NoError(t, writer.SendTyping(false))
+	require.NoError(t, writer.SendTyping(false))
+	retry.Run(t, func(r *retry.R) {
+		requireTyping(r, watcher.TypingUpdates(), true, false)
+	})
+
+	require.NoError(t, writer.SendTyping(false))
+	require.NoError(t, writer.SendMessage("done typing"))
+	retry.Run(t, func(r *retry.R) {
+		requireTyping(r, watcher.TypingUpdates(), true, false, true, false)
+		require.Len(r, watcher.Messages(), 2)
+	})
+
+	require.NoError(t, writer.SendTyping(false))
+	require.NoError(t, writer.SendTyping(true))
+	retry.Run(t, func(r *retry.R) {
+		requireTyping(r, watcher.TypingUpdates(), true, false, true, false, true, false)
+	})
+
+	require.Empty(t, writer.TypingUpdates())
+
+	// An indicator whose instance never publishes the stop expires on the receiving side.
+	require.NoError(t, storage.PublishTyping(shortCallCtx(), roomID, &server.TypingUpdate{
+		UserID: "typing-crashed", Typing: true, ExpiresAt: time.Now().Add(typingCfg.TypingTimeout),
+	}))
+	retry.Run(t, func(r *retry.R) {
+		updates := watcher.TypingUpdates()
+		require.Len(r, updates, 8)
+		for _, update := range updates[6:] {
+			require.Equal(r, "typing-crashed", update.UserId)
+		}
+		require.True(r, updates[6].Typing)
+		require.False(r, updates[7].Typing)
+	})
+}
+
+// testPresence checks that users connected through different replicas see each other come and go, and that
+// users of an instance that stopped sending heartbeats go offline once their presence expires.
+func testPresence(t *testing.T, cfg *config.Config, storage, replicaStorage server.Storage) {
+	presenceCfg := *cfg
+	presenceCfg.PresenceTTL = 300 * time.Millisecond
+
+	watcher := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, &presenceCfg, storage)), "presence-watcher")
+	visitor := createClient(t, fmt.Sprintf("localhost:%d", startServer(t, &presenceCfg, replicaStorage)), "presence-visitor")
+
+	roomID, err := watcher.CreateRoom(shortCallCtx(), "presence")
+	require.NoError(t, err)
+
+	go func() {
+		_ = watcher.Connect(context.Background(), roomID)
+	}()
+	watcher.WaitConnected()
+
+	requirePresence := func(r require.TestingT, userIDs ...string) {
+		online, err := visitor.GetPresence(shortCallCtx(), roomID)
+		require.NoError(r, err)
+		require.Equal(r, userIDs, online)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		requirePresence(r, watcher.UserID())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = visitor.Connect(ctx, roomID)
+	}()
+	visitor.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		requirePresence(r, visitor.UserID(), watcher.UserID())
+		require.True(r, hasPresence(watcher.PresenceUpdates(), visitor.UserID(), true))
+	})
+
+	// A user of an instance that crashed without taking its users offline.
+	require.NoError(t, storage.SetPresence(shortCallCtx(), roomID, "crashed", []string{"presence-ghost"}, time.Second))
+	retry.Run(t, func(r *retry.R) {
+		require.True(r, hasPresence(watcher.PresenceUpdates(), "presence-ghost", true))
 	})
 
-	if err := rdb.Ping(shortCallCtx()).Err(); err != nil {
-		require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		require.True(r, hasPresence(watcher.PresenceUpdates(), "presence-ghost", false))
+		requirePresence(r, visitor.UserID(), watcher.UserID())
+	})
+
+	cancel()
+	retry.Run(t, func(r *retry.R) {
+		require.True(r, hasPresence(watcher.PresenceUpdates(), visitor.UserID(), false))
+		requirePresence(r, watcher.UserID())
+	})
+
+	_, err = watcher.GetPresence(shortCallCtx(), "rooms:unknown:data")
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func hasPresence(updates []*chat.PresenceUpdate, userID string, online bool) bool {
+	for _, update := range updates {
+		if update.UserId == userID && update.Online == online {
+			return true
+		}
 	}
 
-	defer func() {
-		_ = rdb.Close()
-	}()
+	return false
+}
+
+// testAuth checks that requests need a valid token and can not be made on behalf of another user.
+func testAuth(t *testing.T, cfg *config.Config, storage server.Storage) {
+	const secret = "auth-test-secret"
+
+	authCfg := *cfg
+	authCfg.AuthJWTSecret = secret
+
+	_, port := runTestServer(t, &authCfg, storage)
+	addr := fmt.Sprintf("localhost:%d", port)
 
-	srv, err := server.NewServer(cfg, rdb)
+	token := func(userID string, expiresIn time.Duration) grpc.DialOption {
+		claims := jwt.RegisteredClaims{
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		require.NoError(t, err)
+
+		return grpc.WithPerRPCCredentials(bearerToken(signed))
+	}
+
+	alice := createClient(t, addr, "alice", token("alice", time.Minute))
+	roomID, err := alice.CreateRoom(shortCallCtx(), "auth")
 	require.NoError(t, err)
 
+	anonymous := createClient(t, addr, "alice")
+	_, err = anonymous.CreateRoom(shortCallCtx(), "auth")
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	expired := createClient(t, addr, "alice", token("alice", -time.Minute))
+	_, err = expired.CreateRoom(shortCallCtx(), "auth")
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	impostor := createClient(t, addr, "alice", token("mallory", time.Minute))
+	_, err = impostor.CreateRoom(shortCallCtx(), "auth")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = impostor.client.DeleteRoom(shortCallCtx(), &chat.DeleteRoomRequest{RoomId: roomID, UserId: "alice"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = impostor.Connect(ctx, roomID)
+	require.Equal(t, codes.PermissionDenied, status.Code(errors.Unwrap(err)))
+
 	go func() {
-		if err = srv.Start(); err != nil {
-			require.NoError(t, err)
-		}
+		_ = alice.Connect(ctx, roomID)
 	}()
+	alice.WaitConnected()
 
-	var port int
+	require.NoError(t, alice.SendMessage("authenticated"))
 	retry.Run(t, func(r *retry.R) {
-		port, err = srv.Port()
-		if err != nil {
-			require.NoError(r, err)
-		}
+		messages := alice.Messages()
+		require.Len(r, messages, 1)
+		require.Equal(r, "alice", messages[0].UserId)
 	})
+}
 
-	tests(t, port)
+// bearerToken sends the token in the authorization header of every call.
+type bearerToken string
+
+func (b bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
 }
 
-func tests(t *testing.T, port int) {
-	t.Logf("starting integration_tests on port %d", port)
+func (b bearerToken) RequireTransportSecurity() bool {
+	return false
+}
 
-	addr := fmt.Sprintf("localhost:%d", port)
+func requireNumbers(t *testing.T, messages []*chat.Message, from, to int64) {
+	t.Helper()
 
-	var clients []*RoomClient
-	for i := 0; i < 10; i++ {
-		userID := fmt.Sprintf("user-%d", i)
-		clients = append(clients, createClient(t, addr, userID))
+	require.Len(t, messages, int(to-from+1))
+	for i, m := range messages {
+		require.Equal(t, from+int64(i), m.Number)
 	}
+}
 
-	t.Log("clients created")
-
-	creator := clients[0]
+func testReplicas(t *testing.T, port, replicaPort int) {
+	alice := createClient(t, fmt.Sprintf("localhost:%d", port), "alice")
+	bob := createClient(t, fmt.Sprintf("localhost:%d", replicaPort), "bob")
 
-	roomID, err := creator.CreateRoom(shortCallCtx(), "room-1")
+	roomID, err := alice.CreateRoom(shortCallCtx(), "replicas")
 	require.NoError(t, err)
-	t.Logf("room created: %s", roomID)
 
-	testCtx, testCancel := context.WithCancel(context.Background())
-	connections, connectionCtx := errgroup.WithContext(testCtx)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	for _, client := range clients {
+	for _, client := range []*RoomClient{alice, bob} {
 		client := client
-		connections.Go(func() error {
-			return client.Connect(connectionCtx, roomID)
-		})
-	}
-	t.Log("all clients initiated connection")
-
-	for _, client := range clients {
+		go func() {
+			_ = client.Connect(ctx, roomID)
+		}()
 		client.WaitConnected()
 	}
-	t.Log("all clients connected")
 
-	messagesCount := len(clients) * 100
-	for i := 0; i < messagesCount; i++ {
-		clientN, messageN := i%len(clients), i/len(clients)
+	retry.Run(t, func(r *retry.R) {
+		require.NoError(r, alice.SendMessage("hello from alice"))
+		require.NoError(r, bob.SendMessage("hello from bob"))
+		require.NotEmpty(r, alice.Messages())
+		require.NotEmpty(r, bob.Messages())
+	})
 
-		text := fmt.Sprintf("message-%d-%d", messageN, clientN)
-		sendErr := clients[clientN].SendMessage(text)
-		require.NoError(t, sendErr)
-	}
-	t.Log("all clients sent messages")
+	retry.Run(t, func(r *retry.R) {
+		aliceMessages, bobMessages := alice.Messages(), bob.Messages()
+		require.Equal(r, len(aliceMessages), len(bobMessages))
+		for i := range aliceMessages {
+			require.Equal(r, int64(i), aliceMessages[i].Number)
+			require.Equal(r, aliceMessages[i].Text, bobMessages[i].Text)
+		}
+	})
+}
 
-	time.Sleep(time.Second)
+func testSessions(t *testing.T, addr string) {
+	owner := createClient(t, addr, "multi-device")
 
-	testCancel()
-	if err = connections.Wait(); !isCancelled(err) {
-		require.NoError(t, err)
-	}
+	roomID, err := owner.CreateRoom(shortCallCtx(), "sessions")
+	require.NoError(t, err)
+
+	phoneCtx, phoneCancel := context.WithCancel(context.Background())
+	defer phoneCancel()
+	desktopCtx, desktopCancel := context.WithCancel(context.Background())
+	defer desktopCancel()
+
+	phone, desktop, tablet := createClient(t, addr, owner.UserID()), createClient(t, addr, owner.UserID()), createClient(t, addr, owner.UserID())
+	go func() {
+		_ = phone.Connect(phoneCtx, roomID)
+	}()
+	phone.WaitConnected()
+	go func() {
+		_ = desktop.Connect(desktopCtx, roomID)
+	}()
+	desktop.WaitConnected()
+
+	retry.Run(t, func(r *retry.R) {
+		require.NoError(r, phone.SendMessage("from phone"))
+		require.NotEmpty(r, desktop.Messages())
+	})
+
+	require.Equal(t, codes.ResourceExhausted, status.Code(tablet.Connect(context.Background(), roomID)))
+
+	phoneCancel()
+
+	retry.Run(t, func(r *retry.R) {
+		require.NoError(r, desktop.SendMessage("from desktop"))
+		require.Equal(r, "from desktop", desktop.Messages()[len(desktop.Messages())-1].Text)
+	})
+}
+
+func testRoomLifecycle(t *testing.T, addr string) {
+	owner, member := createClient(t, addr, "owner"), createClient(t, addr, "member")
+
+	roomID, err := owner.CreateRoom(shortCallCtx(), "lifecycle")
+	require.NoError(t, err)
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- member.Connect(context.Background(), roomID)
+	}()
+	member.WaitConnected()
+
+	_, err = member.client.UpdateRoom(shortCallCtx(), &chat.UpdateRoomRequest{RoomId: roomID, UserId: member.UserID(), Name: "stolen"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	res, err := owner.client.UpdateRoom(shortCallCtx(), &chat.UpdateRoomRequest{RoomId: roomID, UserId: owner.UserID(), Name: "renamed"})
+	require.NoError(t, err)
+	require.Equal(t, "renamed", res.Room.Name)
+
+	rooms, _, err := owner.ListRooms(shortCallCtx(), &chat.ListRoomsRequest{OwnerId: owner.UserID(), NamePrefix: "lifecycle"})
+	require.NoError(t, err)
+	require.Empty(t, rooms)
+
+	archived, err := owner.client.ArchiveRoom(shortCallCtx(), &chat.ArchiveRoomRequest{RoomId: roomID, UserId: owner.UserID()})
+	require.NoError(t, err)
+	require.True(t, archived.Room.Archived)
+
+	retry.Run(t, func(r *retry.R) {
+		updates := member.RoomUpdates()
+		require.Len(r, updates, 2)
+		require.Equal(r, "renamed", updates[0].Name)
+		require.True(r, updates[1].Archived)
+	})
+
+	_, err = owner.client.DeleteRoom(shortCallCtx(), &chat.DeleteRoomRequest{RoomId: roomID, UserId: owner.UserID()})
+	require.NoError(t, err)
+	require.Equal(t, codes.NotFound, status.Code(<-connected))
+
+	_, err = owner.GetRoom(shortCallCtx(), roomID)
+	require.Equal(t, codes.NotFound, status.Code(err))
 }
 
 type RoomClient struct {
@@ -124,14 +1800,21 @@ type RoomClient struct {
 	userID    string
 	connected chan struct{}
 
-	messages   []*chat.Message
-	messagesMx sync.RWMutex
+	messages    []*chat.Message
+	roomUpdates []*chat.Room
+	replyCounts map[int64]int32
+	reactions   []*chat.ReactionUpdate
+	typing      []*chat.TypingUpdate
+	presence    []*chat.PresenceUpdate
+	receipts    []*chat.ReadReceipt
+	gap         bool
+	messagesMx  sync.RWMutex
 
 	sendMx sync.Mutex
 }
 
-func createClient(t *testing.T, addr string, userID string) *RoomClient {
-	c, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func createClient(t *testing.T, addr string, userID string, opts ...grpc.DialOption) *RoomClient {
+	c, err := grpc.NewClient(addr, append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)...)
 	require.NoError(t, err)
 
 	client := chat.NewChatServiceClient(c)
@@ -158,7 +1841,66 @@ func (c *RoomClient) CreateRoom(ctx context.Context, name string) (string, error
 	return res.RoomId, nil
 }
 
+func (c *RoomClient) GetReadReceipts(ctx context.Context, roomID string) ([]*chat.ReadReceipt, error) {
+	res, err := c.client.GetReadReceipts(ctx, &chat.GetReadReceiptsRequest{
+		UserId: c.userID,
+		RoomId: roomID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Receipts, nil
+}
+
+func (c *RoomClient) GetPresence(ctx context.Context, roomID string) ([]string, error) {
+	res, err := c.client.GetPresence(ctx, &chat.GetPresenceRequest{
+		UserId: c.userID,
+		RoomId: roomID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.UserIds, nil
+}
+
+func (c *RoomClient) GetRoom(ctx context.Context, roomID string) (*chat.Room, error) {
+	res, err := c.client.GetRoom(ctx, &chat.GetRoomRequest{
+		RoomId: roomID,
+		UserId: c.userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Room, nil
+}
+
+func (c *RoomClient) ListRooms(ctx context.Context, request *chat.ListRoomsRequest) ([]*chat.Room, string, error) {
+	request.UserId = c.userID
+	res, err := c.client.ListRooms(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res.Rooms, res.NextPageToken, nil
+}
+
 func (c *RoomClient) Connect(ctx context.Context, roomID string) error {
+	return c.ConnectFrom(ctx, roomID, -1)
+}
+
+func (c *RoomClient) ConnectFrom(ctx context.Context, roomID string, lastReadMessageNumber int64) error {
+	return c.connect(ctx, roomID, &lastReadMessageNumber)
+}
+
+// ConnectFromReadCursor connects without the last read message number, so the server uses the read cursor of the user.
+func (c *RoomClient) ConnectFromReadCursor(ctx context.Context, roomID string) error {
+	return c.connect(ctx, roomID, nil)
+}
+
+func (c *RoomClient) connect(ctx context.Context, roomID string, lastReadMessageNumber *int64) error {
 	connectCtx, connectCancel := context.WithCancel(context.Background())
 	defer connectCancel()
 
@@ -172,7 +1914,7 @@ func (c *RoomClient) Connect(ctx context.Context, roomID string) error {
 			ConnectRoom: &chat.ConnectRequest_ConnectRoom{
 				UserId:                c.userID,
 				RoomId:                roomID,
-				LastReadMessageNumber: -1,
+				LastReadMessageNumber: lastReadMessageNumber,
 			},
 		},
 	})
@@ -208,7 +1950,24 @@ func (c *RoomClient) Connect(ctx context.Context, roomID string) error {
 		case *chat.ConnectResponse_Message:
 			c.addMessages(p.Message)
 		case *chat.ConnectResponse_MessageList:
+			c.setGap(p.MessageList.Gap)
 			c.addMessages(p.MessageList.Messages...)
+		case *chat.ConnectResponse_RoomUpdated:
+			c.addRoomUpdate(p.RoomUpdated)
+		case *chat.ConnectResponse_MessageEdited:
+			c.replaceMessage(p.MessageEdited)
+		case *chat.ConnectResponse_MessageDeleted:
+			c.replaceMessage(p.MessageDeleted)
+		case *chat.ConnectResponse_ThreadUpdated:
+			c.setReplyCount(p.ThreadUpdated)
+		case *chat.ConnectResponse_ReactionUpdated:
+			c.addReactionUpdate(p.ReactionUpdated)
+		case *chat.ConnectResponse_Typing:
+			c.addTypingUpdate(p.Typing)
+		case *chat.ConnectResponse_Presence:
+			c.addPresenceUpdate(p.Presence)
+		case *chat.ConnectResponse_ReadReceipt:
+			c.addReadReceipt(p.ReadReceipt)
 		}
 	}
 }
@@ -218,14 +1977,42 @@ func (c *RoomClient) WaitConnected() {
 }
 
 func (c *RoomClient) SendMessage(text string) error {
+	return c.send(&chat.ConnectRequest_SendMessage{Text: text})
+}
+
+func (c *RoomClient) SendReply(text string, replyToNumber int64) error {
+	return c.send(&chat.ConnectRequest_SendMessage{Text: text, ReplyToNumber: &replyToNumber})
+}
+
+func (c *RoomClient) send(message *chat.ConnectRequest_SendMessage) error {
 	c.sendMx.Lock()
 	defer c.sendMx.Unlock()
 
 	return c.stream.Send(&chat.ConnectRequest{
 		Payload: &chat.ConnectRequest_SendMessage_{
-			SendMessage: &chat.ConnectRequest_SendMessage{
-				Text: text,
-			},
+			SendMessage: message,
+		},
+	})
+}
+
+func (c *RoomClient) MarkRead(number int64) error {
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
+
+	return c.stream.Send(&chat.ConnectRequest{
+		Payload: &chat.ConnectRequest_MarkRead_{
+			MarkRead: &chat.ConnectRequest_MarkRead{Number: number},
+		},
+	})
+}
+
+func (c *RoomClient) SendTyping(stopped bool) error {
+	c.sendMx.Lock()
+	defer c.sendMx.Unlock()
+
+	return c.stream.Send(&chat.ConnectRequest{
+		Payload: &chat.ConnectRequest_Typing_{
+			Typing: &chat.ConnectRequest_Typing{Stopped: stopped},
 		},
 	})
 }
@@ -234,7 +2021,58 @@ func (c *RoomClient) Messages() []*chat.Message {
 	c.messagesMx.RLock()
 	defer c.messagesMx.RUnlock()
 
-	return c.messages
+	// Edits replace messages in place, so callers get a copy.
+	return append([]*chat.Message(nil), c.messages...)
+}
+
+func (c *RoomClient) RoomUpdates() []*chat.Room {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return c.roomUpdates
+}
+
+// ReplyCount returns the reply count of the thread from its last update.
+func (c *RoomClient) ReplyCount(number int64) int32 {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return c.replyCounts[number]
+}
+
+func (c *RoomClient) ReactionUpdates() []*chat.ReactionUpdate {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return append([]*chat.ReactionUpdate(nil), c.reactions...)
+}
+
+func (c *RoomClient) TypingUpdates() []*chat.TypingUpdate {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return append([]*chat.TypingUpdate(nil), c.typing...)
+}
+
+func (c *RoomClient) PresenceUpdates() []*chat.PresenceUpdate {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return append([]*chat.PresenceUpdate(nil), c.presence...)
+}
+
+func (c *RoomClient) ReadReceipts() []*chat.ReadReceipt {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return append([]*chat.ReadReceipt(nil), c.receipts...)
+}
+
+func (c *RoomClient) Gap() bool {
+	c.messagesMx.RLock()
+	defer c.messagesMx.RUnlock()
+
+	return c.gap
 }
 
 func (c *RoomClient) UserID() string {
@@ -248,6 +2086,69 @@ func (c *RoomClient) addMessages(messages ...*chat.Message) {
 	c.messages = append(c.messages, messages...)
 }
 
+func (c *RoomClient) addReactionUpdate(update *chat.ReactionUpdate) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	c.reactions = append(c.reactions, update)
+}
+
+func (c *RoomClient) addTypingUpdate(update *chat.TypingUpdate) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	c.typing = append(c.typing, update)
+}
+
+func (c *RoomClient) addPresenceUpdate(update *chat.PresenceUpdate) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	c.presence = append(c.presence, update)
+}
+
+func (c *RoomClient) addReadReceipt(receipt *chat.ReadReceipt) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	c.receipts = append(c.receipts, receipt)
+}
+
+func (c *RoomClient) setReplyCount(update *chat.ThreadUpdate) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	if c.replyCounts == nil {
+		c.replyCounts = make(map[int64]int32)
+	}
+	c.replyCounts[update.Number] = update.ReplyCount
+}
+
+func (c *RoomClient) replaceMessage(message *chat.Message) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	for i, m := range c.messages {
+		if m.Number == message.Number {
+			c.messages[i] = message
+		}
+	}
+}
+
+func (c *RoomClient) addRoomUpdate(room *chat.Room) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	c.roomUpdates = append(c.roomUpdates, room)
+}
+
+func (c *RoomClient) setGap(gap bool) {
+	c.messagesMx.Lock()
+	defer c.messagesMx.Unlock()
+
+	c.gap = gap
+}
+
 func shortCallCtx() context.Context {
 	ctx, f := context.WithTimeout(context.Background(), time.Second)
 	_ = f
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DavidMovas/chat-rooms/apis/chat"
+	"github.com/DavidMovas/chat-rooms/internal/server"
+	"github.com/DavidMovas/chat-rooms/internal/tlsconfig"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TestServerMutualTLS checks that clients need a certificate signed by the client CA, that the user
+// is taken from its common name and that a rotated server certificate is served without a restart.
+func TestServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	ca.writeCert(t, filepath.Join(dir, "ca.pem"))
+	ca.issue(t, "localhost", 1).write(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	ca.issue(t, "alice", 2).write(t, filepath.Join(dir, "alice.pem"), filepath.Join(dir, "alice-key.pem"))
+
+	cfg := testConfig()
+	cfg.TLSCertFile = filepath.Join(dir, "server.pem")
+	cfg.TLSKeyFile = filepath.Join(dir, "server-key.pem")
+	cfg.TLSClientCAFile = filepath.Join(dir, "ca.pem")
+
+	_, port := runTestServer(t, cfg, server.NewMemoryStorage())
+	addr := fmt.Sprintf("localhost:%d", port)
+
+	clientCreds := func(certFile, keyFile string) grpc.DialOption {
+		tlsCfg, err := tlsconfig.NewClientConfig(filepath.Join(dir, "ca.pem"), certFile, keyFile)
+		require.NoError(t, err)
+		return grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg))
+	}
+	aliceCreds := clientCreds(filepath.Join(dir, "alice.pem"), filepath.Join(dir, "alice-key.pem"))
+
+	alice := createClient(t, addr, "alice", aliceCreds)
+	roomID, err := alice.CreateRoom(shortCallCtx(), "mtls")
+	require.NoError(t, err)
+
+	impostor := createClient(t, addr, "bob", aliceCreds)
+	_, err = impostor.CreateRoom(shortCallCtx(), "mtls")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	anonymous := createClient(t, addr, "alice", clientCreds("", ""))
+	_, err = anonymous.CreateRoom(shortCallCtx(), "mtls")
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = alice.Connect(ctx, roomID)
+	}()
+	alice.WaitConnected()
+
+	require.NoError(t, alice.SendMessage("over mtls"))
+	require.Eventually(t, func() bool {
+		return len(alice.Messages()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	serverSerial := func() int64 {
+		rotated := createClient(t, addr, "alice", aliceCreds)
+
+		var p peer.Peer
+		_, err := rotated.client.GetRoom(shortCallCtx(), &chat.GetRoomRequest{RoomId: roomID, UserId: "alice"}, grpc.Peer(&p))
+		require.NoError(t, err)
+
+		return p.AuthInfo.(credentials.TLSInfo).State.PeerCertificates[0].SerialNumber.Int64()
+	}
+	require.Equal(t, int64(1), serverSerial())
+
+	ca.issue(t, "localhost", 3).write(t, filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	require.Equal(t, int64(3), serverSerial())
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+type testCert struct {
+	der []byte
+	key *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a certificate for both server and client authentication, localhost is also its DNS name.
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return &testCert{der: der, key: key}
+}
+
+func (ca *testCA) writeCert(t *testing.T, path string) {
+	writePEM(t, path, "CERTIFICATE", ca.cert.Raw)
+}
+
+// write replaces each file with a rename, so the server never reads a half written one.
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	key, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	writePEM(t, keyFile, "EC PRIVATE KEY", key)
+	writePEM(t, certFile, "CERTIFICATE", c.der)
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
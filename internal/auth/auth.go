@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	ErrMissingToken       = errors.New("missing bearer token")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrInvalidCertificate = errors.New("invalid client certificate")
+)
+
+type contextKey struct{}
+
+var contextUserKey = contextKey{}
+
+// WithUser returns a context carrying the authenticated user ID.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, contextUserKey, userID)
+}
+
+// UserFromContext returns the authenticated user ID, ok is false when the request was not authenticated.
+func UserFromContext(ctx context.Context) (userID string, ok bool) {
+	userID, ok = ctx.Value(contextUserKey).(string)
+	return userID, ok
+}
+
+// Authenticator identifies users by the subject of a bearer JWT or, with mutual TLS, by the common name
+// of the client certificate. When both are used the token must belong to the certificate user.
+type Authenticator struct {
+	// keyfunc is nil when tokens are not verified.
+	keyfunc     jwt.Keyfunc
+	options     []jwt.ParserOption
+	clientCerts bool
+}
+
+// NewAuthenticator returns an Authenticator verifying tokens signed with cfg.AuthJWTSecret (HMAC)
+// or with the keys of the cfg.AuthJWKSFile JWK set, and client certificates when cfg.TLSClientCAFile
+// is set. It returns nil when none of them is configured.
+func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
+	clientCerts := cfg.TLSClientCAFile != ""
+
+	var (
+		keyfunc jwt.Keyfunc
+		methods []string
+	)
+
+	switch {
+	case cfg.AuthJWTSecret != "" && cfg.AuthJWKSFile != "":
+		return nil, fmt.Errorf("only one of jwt secret and jwks file can be set")
+	case cfg.AuthJWTSecret != "":
+		secret := []byte(cfg.AuthJWTSecret)
+		keyfunc = func(*jwt.Token) (any, error) {
+			return secret, nil
+		}
+		methods = []string{"HS256", "HS384", "HS512"}
+	case cfg.AuthJWKSFile != "":
+		keys, err := loadJWKS(cfg.AuthJWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load jwks: %w", err)
+		}
+		keyfunc = keys.keyfunc
+		methods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	case clientCerts:
+		return &Authenticator{clientCerts: true}, nil
+	default:
+		return nil, nil
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.AuthIssuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.AuthIssuer))
+	}
+	if cfg.AuthAudience != "" {
+		options = append(options, jwt.WithAudience(cfg.AuthAudience))
+	}
+
+	return &Authenticator{
+		keyfunc:     keyfunc,
+		options:     options,
+		clientCerts: clientCerts,
+	}, nil
+}
+
+// Authenticate identifies the user of the incoming request and returns a context with it.
+func (a *Authenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	var userID string
+	if a.clientCerts {
+		var err error
+		if userID, err = certificateUser(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.keyfunc == nil {
+		return WithUser(ctx, userID), nil
+	}
+
+	tokenUserID, err := a.authenticateToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != "" && tokenUserID != userID {
+		return nil, fmt.Errorf("%w: subject does not match the client certificate", ErrInvalidToken)
+	}
+
+	return WithUser(ctx, tokenUserID), nil
+}
+
+func (a *Authenticator) authenticateToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var token string
+	for _, value := range md.Get("authorization") {
+		scheme, bearer, found := strings.Cut(value, " ")
+		if found && strings.EqualFold(scheme, "bearer") {
+			token = strings.TrimSpace(bearer)
+			break
+		}
+	}
+
+	if token == "" {
+		return "", ErrMissingToken
+	}
+
+	return a.verify(token)
+}
+
+func (a *Authenticator) verify(token string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, err := jwt.ParseWithClaims(token, &claims, a.keyfunc, a.options...); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return "", fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return claims.Subject, nil
+}
+
+// certificateUser returns the common name of the verified client certificate.
+func certificateUser(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("%w: missing peer", ErrInvalidCertificate)
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", fmt.Errorf("%w: missing verified certificate", ErrInvalidCertificate)
+	}
+
+	userID := info.State.VerifiedChains[0][0].Subject.CommonName
+	if userID == "" {
+		return "", fmt.Errorf("%w: missing common name", ErrInvalidCertificate)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that adds the authenticated user to the context
+func UnaryServerInterceptor(a *Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if public(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		ctx, err = a.Authenticate(ctx)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+type wrapperStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *wrapperStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor that adds the authenticated user to the context
+func StreamServerInterceptor(a *Authenticator) grpc.StreamServerInterceptor {
+	return func(src interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if public(info.FullMethod) {
+			return handler(src, stream)
+		}
+
+		ctx, err := a.Authenticate(stream.Context())
+		if err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		wrapped := &wrapperStream{ServerStream: stream, ctx: ctx}
+		return handler(src, wrapped)
+	}
+}
+
+// public reports whether the method can be called without a token, only server reflection can.
+func public(method string) bool {
+	return strings.HasPrefix(method, "/grpc.reflection.")
+}
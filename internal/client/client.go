@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"os"
 	"sync"
 	"time"
 
@@ -15,7 +16,9 @@ import (
 
 	"github.com/DavidMovas/chat-rooms/apis/chat"
 	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/DavidMovas/chat-rooms/internal/tlsconfig"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -36,9 +39,14 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*communicateDuration)
 	defer cancel()
 
+	creds, err := transportCredentials()
+	if err != nil {
+		panic(err)
+	}
+
 	var users []*user
 	for i := 0; i < clientsAmount; i++ {
-		c, err := grpc.NewClient(fmt.Sprintf("localhost:%d", cfg.Port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		c, err := grpc.NewClient(fmt.Sprintf("localhost:%d", cfg.Port), grpc.WithTransportCredentials(creds))
 		if err != nil {
 			panic(err)
 		}
@@ -99,6 +107,22 @@ func main() {
 	fmt.Println("all users disconnected")
 }
 
+// transportCredentials uses TLS when TLS_CA_FILE is set, TLS_CERT_FILE and TLS_KEY_FILE are the client
+// certificate for mutual TLS. Without them the connection is not encrypted.
+func transportCredentials() (credentials.TransportCredentials, error) {
+	caFile := os.Getenv("TLS_CA_FILE")
+	if caFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsCfg, err := tlsconfig.NewClientConfig(caFile, os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}
+
 type user struct {
 	id     string
 	name   string
@@ -137,9 +161,8 @@ func (u *user) connect(ctx context.Context) error {
 	err = u.stream.Send(&chat.ConnectRequest{
 		Payload: &chat.ConnectRequest_ConnectRoom_{
 			ConnectRoom: &chat.ConnectRequest_ConnectRoom{
-				RoomId:                u.roomID,
-				UserId:                u.id,
-				LastReadMessageNumber: -1,
+				RoomId: u.roomID,
+				UserId: u.id,
 			},
 		},
 	})
@@ -22,7 +22,56 @@ type Config struct {
 	Local        bool          `env:"LOCAL" envDefault:"false"`
 	LogLevel     string        `env:"LOG_LEVEL" envDefault:"warn"`
 	Port         int           `env:"PORT" envDefault:"55555"`
+	MetricsPort  int           `env:"METRICS_PORT" envDefault:"0"`
 	RedisURL     string        `env:"REDIS_URL" envDefault:"localhost:6379"`
 	MaxMessages  int           `env:"MAX_MESSAGES" envDefault:"1000"`
 	MaxRetention time.Duration `env:"MAX_RETENTION" envDefault:"168h"`
+
+	// RetentionInterval is how often messages beyond MaxMessages and MaxRetention are deleted, 0 disables it.
+	RetentionInterval time.Duration `env:"RETENTION_INTERVAL" envDefault:"10m"`
+
+	// HubIdleTTL is how long a room hub without connections stays loaded, 0 keeps hubs until exit.
+	HubIdleTTL time.Duration `env:"HUB_IDLE_TTL" envDefault:"30m"`
+
+	// AuthJWTSecret or AuthJWKSFile enables authentication with bearer JWTs whose subject is the user ID,
+	// tokens are verified with the HMAC secret or the keys of the local JWK set file.
+	AuthJWTSecret string `env:"AUTH_JWT_SECRET"`
+	AuthJWKSFile  string `env:"AUTH_JWKS_FILE"`
+	AuthIssuer    string `env:"AUTH_ISSUER"`
+	AuthAudience  string `env:"AUTH_AUDIENCE"`
+
+	// TLSCertFile and TLSKeyFile enable TLS, the files are reloaded when they change. TLSClientCAFile
+	// additionally requires client certificates signed by one of its CAs, their common name is the user ID.
+	TLSCertFile     string `env:"TLS_CERT_FILE"`
+	TLSKeyFile      string `env:"TLS_KEY_FILE"`
+	TLSClientCAFile string `env:"TLS_CLIENT_CA_FILE"`
+
+	// StorageDriver is one of "redis", "sqlite" or "memory". Only redis can be shared by several instances.
+	StorageDriver string `env:"STORAGE_DRIVER" envDefault:"redis"`
+	SQLitePath    string `env:"SQLITE_PATH" envDefault:"chat.db"`
+
+	// RedisMessageLog is "sorted-set" or "stream". Streams are trimmed to about RedisStreamMaxLen
+	// entries on every message, 0 keeps all of them.
+	RedisMessageLog   string `env:"REDIS_MESSAGE_LOG" envDefault:"sorted-set"`
+	RedisStreamMaxLen int    `env:"REDIS_STREAM_MAX_LEN" envDefault:"0"`
+
+	// SlowConsumerPolicy is one of "block", "drop-oldest" or "disconnect".
+	SlowConsumerPolicy   string        `env:"SLOW_CONSUMER_POLICY" envDefault:"block"`
+	SlowConsumerTimeout  time.Duration `env:"SLOW_CONSUMER_TIMEOUT" envDefault:"5s"`
+	ConnectionBufferSize int           `env:"CONNECTION_BUFFER_SIZE" envDefault:"64"`
+
+	// MaxSessionsPerUser limits simultaneous connections of a user to a room, 0 means no limit.
+	MaxSessionsPerUser int `env:"MAX_SESSIONS_PER_USER" envDefault:"5"`
+
+	// InviteTTL is how long invites created without an explicit lifetime are valid.
+	InviteTTL time.Duration `env:"INVITE_TTL" envDefault:"168h"`
+
+	// TypingTimeout is how long a typing indicator lasts without being refreshed by the client,
+	// TypingThrottle is the minimum interval between broadcasts of the indicator of a user, 0 disables throttling.
+	TypingTimeout  time.Duration `env:"TYPING_TIMEOUT" envDefault:"5s"`
+	TypingThrottle time.Duration `env:"TYPING_THROTTLE" envDefault:"2s"`
+
+	// PresenceTTL is how long the presence of users connected through an instance outlives its last heartbeat,
+	// so users of a crashed instance go offline. Instances send heartbeats three times per PresenceTTL.
+	PresenceTTL time.Duration `env:"PRESENCE_TTL" envDefault:"30s"`
 }
@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// SlowConsumer counts how often each slow consumer policy fires, keyed by the policy action.
+var SlowConsumer = expvar.NewMap("slow_consumer")
+
+// Retention counts retention runs and what they removed.
+var Retention = expvar.NewMap("retention")
+
+// LoadedHubs is the number of room hubs loaded in memory.
+var LoadedHubs = expvar.NewInt("loaded_hubs")
+
+// Handler returns a http.Handler that serves all metrics in JSON format.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -1,20 +1,75 @@
 package server
 
 import (
+	"time"
+
 	"github.com/DavidMovas/chat-rooms/apis/chat"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func mapToAPIMessage(m *Message) *chat.Message {
 	return &chat.Message{
-		Number:    int64(m.Number),
-		RoomId:    m.RoomID,
-		UserId:    m.UserID,
-		Text:      m.Text,
-		CreatedAt: timestamppb.New(m.CreatedAt),
+		Number:        int64(m.Number),
+		RoomId:        m.RoomID,
+		UserId:        m.UserID,
+		Text:          m.Text,
+		CreatedAt:     timestamppb.New(m.CreatedAt),
+		ReplyToNumber: mapToAPINumber(m.ReplyTo),
+		ReplyCount:    int32(m.ReplyCount),
+		EditedAt:      mapToAPITimestamp(m.EditedAt),
+		DeletedAt:     mapToAPITimestamp(m.DeletedAt),
+		DeletedBy:     m.DeletedBy,
+		Reactions:     mapToAPIReactions(m.Reactions),
 	}
 }
 
+func mapToAPIReactions(reactions []*Reaction) []*chat.Reaction {
+	if len(reactions) == 0 {
+		return nil
+	}
+
+	apiReactions := make([]*chat.Reaction, len(reactions))
+	for i, r := range reactions {
+		apiReactions[i] = &chat.Reaction{
+			Emoji:   r.Emoji,
+			Count:   int32(len(r.UserIDs)),
+			UserIds: r.UserIDs,
+		}
+	}
+
+	return apiReactions
+}
+
+func mapToAPINumber(number *int) *int64 {
+	if number == nil {
+		return nil
+	}
+
+	n := int64(*number)
+	return &n
+}
+
+// mapToAPITimestamp leaves zero times unset.
+func mapToAPITimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+func mapToAPIMessageRevisions(revisions []*MessageRevision) []*chat.MessageRevision {
+	apiRevisions := make([]*chat.MessageRevision, len(revisions))
+	for i, r := range revisions {
+		apiRevisions[i] = &chat.MessageRevision{
+			Text:      r.Text,
+			CreatedAt: timestamppb.New(r.CreatedAt),
+		}
+	}
+
+	return apiRevisions
+}
+
 func mapToAPIMessageList(messages []*Message) *chat.MessageList {
 	apiMessages := make([]*chat.Message, len(messages))
 	for i, m := range messages {
@@ -25,3 +80,206 @@ func mapToAPIMessageList(messages []*Message) *chat.MessageList {
 		Messages: apiMessages,
 	}
 }
+
+func mapToAPIUnreadMessageList(messages []*Message, gap bool) *chat.MessageList {
+	list := mapToAPIMessageList(messages)
+	list.Gap = gap
+
+	return list
+}
+
+func mapToAPIRoom(r *RoomInfo) *chat.Room {
+	return &chat.Room{
+		Id:                r.ID,
+		OwnerId:           r.OwnerID,
+		Name:              r.Name,
+		CreatedAt:         timestamppb.New(r.CreatedAt),
+		MessageCount:      r.MessageCount,
+		LastMessageNumber: int64(r.LastMessageNumber),
+		Archived:          r.Archived,
+		Private:           r.Private,
+
+		MaxMessages:         int32(r.MaxMessages),
+		MaxRetentionSeconds: int64(r.MaxRetention / time.Second),
+	}
+}
+
+func mapToAPIRooms(rooms []*RoomInfo) []*chat.Room {
+	apiRooms := make([]*chat.Room, len(rooms))
+	for i, r := range rooms {
+		apiRooms[i] = mapToAPIRoom(r)
+	}
+
+	return apiRooms
+}
+
+func mapToAPIMember(m *Member) *chat.Member {
+	return &chat.Member{
+		RoomId:   m.RoomID,
+		UserId:   m.UserID,
+		JoinedAt: timestamppb.New(m.JoinedAt),
+		Role:     mapToAPIRole(m.role()),
+	}
+}
+
+func mapToAPIMembers(members []*Member) []*chat.Member {
+	apiMembers := make([]*chat.Member, len(members))
+	for i, m := range members {
+		apiMembers[i] = mapToAPIMember(m)
+	}
+
+	return apiMembers
+}
+
+func mapToAPIInvite(i *Invite) *chat.Invite {
+	return &chat.Invite{
+		Code:      i.Code,
+		RoomId:    i.RoomID,
+		CreatedBy: i.CreatedBy,
+		CreatedAt: timestamppb.New(i.CreatedAt),
+		ExpiresAt: timestamppb.New(i.ExpiresAt),
+		MaxUses:   int32(i.MaxUses),
+		Uses:      int32(i.Uses),
+	}
+}
+
+func mapToAPIInviteUses(uses []*InviteUse) []*chat.InviteUse {
+	apiUses := make([]*chat.InviteUse, len(uses))
+	for i, u := range uses {
+		apiUses[i] = &chat.InviteUse{
+			Code:      u.Code,
+			RoomId:    u.RoomID,
+			UserId:    u.UserID,
+			CreatedBy: u.CreatedBy,
+			UsedAt:    timestamppb.New(u.UsedAt),
+		}
+	}
+
+	return apiUses
+}
+
+func mapToAPIReadReceipt(r *ReadReceipt) *chat.ReadReceipt {
+	return &chat.ReadReceipt{
+		UserId: r.UserID,
+		Number: int64(r.Number),
+		ReadAt: timestamppb.New(r.ReadAt),
+	}
+}
+
+func mapToAPIReadReceipts(receipts []*ReadReceipt) []*chat.ReadReceipt {
+	apiReceipts := make([]*chat.ReadReceipt, len(receipts))
+	for i, r := range receipts {
+		apiReceipts[i] = mapToAPIReadReceipt(r)
+	}
+
+	return apiReceipts
+}
+
+var apiRoles = map[Role]chat.Role{
+	RoleOwner:     chat.Role_ROLE_OWNER,
+	RoleAdmin:     chat.Role_ROLE_ADMIN,
+	RoleModerator: chat.Role_ROLE_MODERATOR,
+	RoleMember:    chat.Role_ROLE_MEMBER,
+	RoleReadOnly:  chat.Role_ROLE_READ_ONLY,
+}
+
+func mapToAPIRole(r Role) chat.Role {
+	return apiRoles[r]
+}
+
+// mapFromAPIRole returns an empty role for unknown API roles.
+func mapFromAPIRole(r chat.Role) Role {
+	for role, apiRole := range apiRoles {
+		if apiRole == r {
+			return role
+		}
+	}
+
+	return ""
+}
+
+func mapToAPIEvent(e *Event) *chat.ConnectResponse {
+	switch {
+	case e.Message != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_Message{
+				Message: mapToAPIMessage(e.Message),
+			},
+		}
+	case e.Edited != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_MessageEdited{
+				MessageEdited: mapToAPIMessage(e.Edited),
+			},
+		}
+	case e.Deleted != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_MessageDeleted{
+				MessageDeleted: mapToAPIMessage(e.Deleted),
+			},
+		}
+	case e.Thread != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_ThreadUpdated{
+				ThreadUpdated: &chat.ThreadUpdate{
+					Number:     int64(e.Thread.Number),
+					ReplyCount: int32(e.Thread.ReplyCount),
+				},
+			},
+		}
+	case e.Reaction != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_ReactionUpdated{
+				ReactionUpdated: &chat.ReactionUpdate{
+					Number: int64(e.Reaction.Number),
+					Emoji:  e.Reaction.Emoji,
+					UserId: e.Reaction.UserID,
+					Added:  e.Reaction.Added,
+					Count:  int32(e.Reaction.Count),
+				},
+			},
+		}
+	case e.Typing != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_Typing{
+				Typing: &chat.TypingUpdate{
+					UserId: e.Typing.UserID,
+					Typing: e.Typing.Typing,
+				},
+			},
+		}
+	case e.Presence != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_Presence{
+				Presence: &chat.PresenceUpdate{
+					UserId: e.Presence.UserID,
+					Online: e.Presence.Online,
+				},
+			},
+		}
+	case e.ReadReceipt != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_ReadReceipt{
+				ReadReceipt: mapToAPIReadReceipt(e.ReadReceipt),
+			},
+		}
+	case e.Room != nil:
+		return &chat.ConnectResponse{
+			Payload: &chat.ConnectResponse_RoomUpdated{
+				RoomUpdated: mapToAPIRoom(&RoomInfo{Room: e.Room}),
+			},
+		}
+	default:
+		return &chat.ConnectResponse{}
+	}
+}
+
+func mapToAPIGap(dropped int64) *chat.ConnectResponse {
+	return &chat.ConnectResponse{
+		Payload: &chat.ConnectResponse_Gap{
+			Gap: &chat.Gap{
+				DroppedEvents: dropped,
+			},
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/DavidMovas/chat-rooms/internal/metrics"
+)
+
+const (
+	defaultConnectionBufferSize = 4
+	defaultSlowConsumerTimeout  = time.Second * 5
+)
+
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerBlock waits for the connection buffer up to the timeout, which is shared by all connections
+	// of a broadcast, then disconnects.
+	SlowConsumerBlock SlowConsumerPolicy = "block"
+	// SlowConsumerDropOldest drops the oldest buffered event and notifies the connection about the gap.
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop-oldest"
+	// SlowConsumerDisconnect disconnects the connection as soon as its buffer is full.
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+type deliveryOptions struct {
+	policy     SlowConsumerPolicy
+	timeout    time.Duration
+	bufferSize int
+}
+
+func newDeliveryOptions(cfg *config.Config) (deliveryOptions, error) {
+	opts := deliveryOptions{
+		policy:     SlowConsumerPolicy(cfg.SlowConsumerPolicy),
+		timeout:    cfg.SlowConsumerTimeout,
+		bufferSize: cfg.ConnectionBufferSize,
+	}
+
+	switch opts.policy {
+	case "":
+		opts.policy = SlowConsumerBlock
+	case SlowConsumerBlock, SlowConsumerDropOldest, SlowConsumerDisconnect:
+	default:
+		return deliveryOptions{}, fmt.Errorf("unknown slow consumer policy %q", cfg.SlowConsumerPolicy)
+	}
+
+	if opts.bufferSize <= 0 {
+		opts.bufferSize = defaultConnectionBufferSize
+	}
+
+	if opts.timeout <= 0 {
+		opts.timeout = defaultSlowConsumerTimeout
+	}
+
+	return opts, nil
+}
+
+// deliveryDeadline is shared by the deliveries of a single broadcast, so connections that stopped reading
+// hold the hub up for one slow consumer timeout in total rather than for one timeout each.
+type deliveryDeadline struct {
+	timeout time.Duration
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+// wait returns a channel closed once the timeout has passed since the first blocked delivery.
+func (d *deliveryDeadline) wait() <-chan struct{} {
+	if d.expired == nil {
+		expired := make(chan struct{})
+		d.expired = expired
+		d.timer = time.AfterFunc(d.timeout, func() {
+			close(expired)
+		})
+	}
+
+	return d.expired
+}
+
+func (d *deliveryDeadline) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// deliver pushes the event to the connection buffer applying the slow consumer policy when it is full,
+// the block policy waits until the deadline of the broadcast. It must be called with h.mx held.
+func (h *RoomHub) deliver(connection *Connection, event *Event, deadline *deliveryDeadline) {
+	select {
+	case connection.EventsCh <- event:
+		return
+	default:
+	}
+
+	opts := h.store.delivery
+	switch opts.policy {
+	case SlowConsumerDropOldest:
+		metrics.SlowConsumer.Add("drop_oldest", 1)
+		select {
+		case <-connection.EventsCh:
+			connection.dropped.Add(1)
+		default:
+		}
+
+		// The hub is the only writer, so there is free space in the buffer now.
+		connection.EventsCh <- event
+	case SlowConsumerDisconnect:
+		metrics.SlowConsumer.Add("disconnect", 1)
+		h.kick(connection, ErrSlowConsumer)
+	default:
+		select {
+		case connection.EventsCh <- event:
+			metrics.SlowConsumer.Add("block", 1)
+		case <-deadline.wait():
+			metrics.SlowConsumer.Add("block_timeout", 1)
+			h.kick(connection, ErrSlowConsumer)
+		}
+	}
+}
+
+// offer pushes an advisory event, such as a typing or presence update, to the connection buffer and drops
+// it when the buffer is full, so connections that fall behind do not hold the hub up. It must be called
+// with h.mx held.
+func (h *RoomHub) offer(connection *Connection, event *Event) {
+	select {
+	case connection.EventsCh <- event:
+	default:
+		metrics.SlowConsumer.Add("drop_advisory", 1)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrRoomNotFound     = errors.New("room not found")
+	ErrRoomDeleted      = errors.New("room has been deleted")
+	ErrRoomArchived     = errors.New("room is archived")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrSlowConsumer     = errors.New("connection is too slow to receive events")
+	ErrTooManySessions  = errors.New("too many sessions of the user in the room")
+	ErrServerStopped    = errors.New("server is stopped")
+	ErrNotStreamLog     = errors.New("redis message log is not a stream")
+	ErrNotMember        = errors.New("user is not a member of the room")
+	ErrOwnerCannotLeave = errors.New("room owner can not leave the room")
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrInviteNotFound   = errors.New("invite not found")
+	ErrInviteExpired    = errors.New("invite has expired")
+	ErrInviteUsedUp     = errors.New("invite has been used up")
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageDeleted   = errors.New("message has been deleted")
+	ErrInvalidEmoji     = errors.New("invalid emoji")
+
+	// errHubEvicted is returned by a hub unloaded for being idle, the room hub has to be loaded again.
+	errHubEvicted = errors.New("room hub has been evicted")
+)
+
+// statusError converts known store errors to gRPC statuses, other errors are wrapped as is.
+func statusError(err error, msg string) error {
+	var code codes.Code
+	switch {
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, ErrRoomDeleted), errors.Is(err, ErrInviteNotFound),
+		errors.Is(err, ErrMessageNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrRoomArchived), errors.Is(err, ErrOwnerCannotLeave),
+		errors.Is(err, ErrInviteExpired), errors.Is(err, ErrInviteUsedUp), errors.Is(err, ErrMessageDeleted):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrPermissionDenied), errors.Is(err, ErrNotMember):
+		code = codes.PermissionDenied
+	case errors.Is(err, ErrSlowConsumer), errors.Is(err, ErrTooManySessions):
+		code = codes.ResourceExhausted
+	case errors.Is(err, ErrServerStopped):
+		code = codes.Unavailable
+	case errors.Is(err, ErrInvalidPageToken), errors.Is(err, ErrInvalidRole), errors.Is(err, ErrInvalidEmoji):
+		code = codes.InvalidArgument
+	default:
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+
+	return status.Errorf(code, "%s: %v", msg, err)
+}
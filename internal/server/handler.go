@@ -7,10 +7,13 @@ import (
 	"log/slog"
 	"time"
 
+	"github.com/DavidMovas/chat-rooms/internal/auth"
 	"github.com/DavidMovas/chat-rooms/internal/config"
 	"github.com/DavidMovas/chat-rooms/internal/log"
 
 	"github.com/DavidMovas/chat-rooms/apis/chat"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var _ chat.ChatServiceServer = (*ChatServer)(nil)
@@ -32,7 +35,11 @@ func NewChatServer(store *Store, cfg *config.Config) *ChatServer {
 }
 
 func (s *ChatServer) CreateRoom(ctx context.Context, request *chat.CreateRoomRequest) (*chat.CreateRoomResponse, error) {
-	room, err := s.store.CreateRoom(ctx, request.UserId, request.Name)
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	room, err := s.store.CreateRoom(ctx, request.UserId, request.Name, request.Private)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create room: %w", err)
 	}
@@ -46,6 +53,451 @@ func (s *ChatServer) CreateRoom(ctx context.Context, request *chat.CreateRoomReq
 	}, nil
 }
 
+func (s *ChatServer) GetRoom(ctx context.Context, request *chat.GetRoomRequest) (*chat.GetRoomResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	room, err := s.store.GetRoom(ctx, request.UserId, request.RoomId)
+	if err != nil {
+		return nil, statusError(err, "failed to get room")
+	}
+
+	return &chat.GetRoomResponse{
+		Room: mapToAPIRoom(room),
+	}, nil
+}
+
+func (s *ChatServer) ListRooms(ctx context.Context, request *chat.ListRoomsRequest) (*chat.ListRoomsResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if request.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+
+	filter := RoomFilter{
+		OwnerID:    request.OwnerId,
+		NamePrefix: request.NamePrefix,
+	}
+
+	rooms, nextPageToken, err := s.store.ListRooms(ctx, request.UserId, filter, int(request.PageSize), request.PageToken)
+	if err != nil {
+		return nil, statusError(err, "failed to list rooms")
+	}
+
+	return &chat.ListRoomsResponse{
+		Rooms:         mapToAPIRooms(rooms),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (s *ChatServer) UpdateRoom(ctx context.Context, request *chat.UpdateRoomRequest) (*chat.UpdateRoomResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	var update RoomUpdate
+	if request.Name != "" {
+		update.Name = &request.Name
+	}
+
+	if request.MaxMessages != nil {
+		if *request.MaxMessages < 0 {
+			return nil, status.Error(codes.InvalidArgument, "max messages can not be negative")
+		}
+
+		maxMessages := int(*request.MaxMessages)
+		update.MaxMessages = &maxMessages
+	}
+
+	if request.MaxRetentionSeconds != nil {
+		if *request.MaxRetentionSeconds < 0 {
+			return nil, status.Error(codes.InvalidArgument, "max retention can not be negative")
+		}
+
+		maxRetention := time.Duration(*request.MaxRetentionSeconds) * time.Second
+		update.MaxRetention = &maxRetention
+	}
+
+	update.Private = request.Private
+
+	if update == (RoomUpdate{}) {
+		return nil, status.Error(codes.InvalidArgument, "room name, retention or privacy is required")
+	}
+
+	room, err := s.store.UpdateRoom(ctx, request.UserId, request.RoomId, update)
+	if err != nil {
+		return nil, statusError(err, "failed to update room")
+	}
+
+	return &chat.UpdateRoomResponse{
+		Room: mapToAPIRoom(room),
+	}, nil
+}
+
+func (s *ChatServer) ArchiveRoom(ctx context.Context, request *chat.ArchiveRoomRequest) (*chat.ArchiveRoomResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	room, err := s.store.ArchiveRoom(ctx, request.UserId, request.RoomId)
+	if err != nil {
+		return nil, statusError(err, "failed to archive room")
+	}
+
+	return &chat.ArchiveRoomResponse{
+		Room: mapToAPIRoom(room),
+	}, nil
+}
+
+func (s *ChatServer) DeleteRoom(ctx context.Context, request *chat.DeleteRoomRequest) (*chat.DeleteRoomResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.DeleteRoom(ctx, request.UserId, request.RoomId); err != nil {
+		return nil, statusError(err, "failed to delete room")
+	}
+
+	if s.isLocal {
+		slog.Info("room deleted", "room_id", request.RoomId, "user_id", request.UserId)
+	}
+
+	return &chat.DeleteRoomResponse{}, nil
+}
+
+func (s *ChatServer) GetHistory(ctx context.Context, request *chat.GetHistoryRequest) (*chat.GetHistoryResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	query := HistoryQuery{
+		Limit: int(request.Limit),
+	}
+	if request.BeforeNumber != nil {
+		before := int(*request.BeforeNumber)
+		query.Before = &before
+	}
+	if request.AfterNumber != nil {
+		after := int(*request.AfterNumber)
+		query.After = &after
+	}
+
+	messages, hasMore, err := s.store.GetHistory(ctx, request.UserId, request.RoomId, query)
+	if err != nil {
+		return nil, statusError(err, "failed to get history")
+	}
+
+	return &chat.GetHistoryResponse{
+		Messages: mapToAPIMessageList(messages).Messages,
+		HasMore:  hasMore,
+	}, nil
+}
+
+func (s *ChatServer) JoinRoom(ctx context.Context, request *chat.JoinRoomRequest) (*chat.JoinRoomResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	member, err := s.store.JoinRoom(ctx, request.UserId, request.RoomId, request.MemberId)
+	if err != nil {
+		return nil, statusError(err, "failed to join room")
+	}
+
+	return &chat.JoinRoomResponse{
+		Member: mapToAPIMember(member),
+	}, nil
+}
+
+func (s *ChatServer) LeaveRoom(ctx context.Context, request *chat.LeaveRoomRequest) (*chat.LeaveRoomResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.LeaveRoom(ctx, request.UserId, request.RoomId); err != nil {
+		return nil, statusError(err, "failed to leave room")
+	}
+
+	return &chat.LeaveRoomResponse{}, nil
+}
+
+func (s *ChatServer) KickMember(ctx context.Context, request *chat.KickMemberRequest) (*chat.KickMemberResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.KickMember(ctx, request.UserId, request.RoomId, request.MemberId); err != nil {
+		return nil, statusError(err, "failed to kick member")
+	}
+
+	if s.isLocal {
+		slog.Info("member kicked", "room_id", request.RoomId, "user_id", request.UserId, "member_id", request.MemberId)
+	}
+
+	return &chat.KickMemberResponse{}, nil
+}
+
+func (s *ChatServer) ListMembers(ctx context.Context, request *chat.ListMembersRequest) (*chat.ListMembersResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	members, nextPageToken, err := s.store.ListMembers(ctx, request.UserId, request.RoomId, int(request.PageSize), request.PageToken)
+	if err != nil {
+		return nil, statusError(err, "failed to list members")
+	}
+
+	return &chat.ListMembersResponse{
+		Members:       mapToAPIMembers(members),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+func (s *ChatServer) GrantRole(ctx context.Context, request *chat.GrantRoleRequest) (*chat.GrantRoleResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	member, err := s.store.GrantRole(ctx, request.UserId, request.RoomId, request.MemberId, mapFromAPIRole(request.Role))
+	if err != nil {
+		return nil, statusError(err, "failed to grant role")
+	}
+
+	if s.isLocal {
+		slog.Info("role granted", "room_id", request.RoomId, "user_id", request.UserId, "member_id", request.MemberId, "role", member.Role)
+	}
+
+	return &chat.GrantRoleResponse{
+		Member: mapToAPIMember(member),
+	}, nil
+}
+
+func (s *ChatServer) RevokeRole(ctx context.Context, request *chat.RevokeRoleRequest) (*chat.RevokeRoleResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	member, err := s.store.RevokeRole(ctx, request.UserId, request.RoomId, request.MemberId)
+	if err != nil {
+		return nil, statusError(err, "failed to revoke role")
+	}
+
+	if s.isLocal {
+		slog.Info("role revoked", "room_id", request.RoomId, "user_id", request.UserId, "member_id", request.MemberId)
+	}
+
+	return &chat.RevokeRoleResponse{
+		Member: mapToAPIMember(member),
+	}, nil
+}
+
+func (s *ChatServer) EditMessage(ctx context.Context, request *chat.EditMessageRequest) (*chat.EditMessageResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if request.Text == "" {
+		return nil, status.Error(codes.InvalidArgument, "message text is required")
+	}
+
+	message, err := s.store.EditMessage(ctx, request.UserId, request.RoomId, int(request.Number), request.Text)
+	if err != nil {
+		return nil, statusError(err, "failed to edit message")
+	}
+
+	return &chat.EditMessageResponse{
+		Message: mapToAPIMessage(message),
+	}, nil
+}
+
+func (s *ChatServer) GetMessageRevisions(ctx context.Context, request *chat.GetMessageRevisionsRequest) (*chat.GetMessageRevisionsResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.store.GetMessageRevisions(ctx, request.UserId, request.RoomId, int(request.Number))
+	if err != nil {
+		return nil, statusError(err, "failed to get message revisions")
+	}
+
+	return &chat.GetMessageRevisionsResponse{
+		Revisions: mapToAPIMessageRevisions(revisions),
+	}, nil
+}
+
+func (s *ChatServer) DeleteMessage(ctx context.Context, request *chat.DeleteMessageRequest) (*chat.DeleteMessageResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	message, err := s.store.DeleteMessage(ctx, request.UserId, request.RoomId, int(request.Number))
+	if err != nil {
+		return nil, statusError(err, "failed to delete message")
+	}
+
+	if s.isLocal {
+		slog.Info("message deleted", "room_id", request.RoomId, "number", request.Number, "user_id", request.UserId)
+	}
+
+	return &chat.DeleteMessageResponse{
+		Message: mapToAPIMessage(message),
+	}, nil
+}
+
+func (s *ChatServer) GetThread(ctx context.Context, request *chat.GetThreadRequest) (*chat.GetThreadResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	var after *int
+	if request.AfterNumber != nil {
+		number := int(*request.AfterNumber)
+		after = &number
+	}
+
+	root, replies, hasMore, err := s.store.GetThread(ctx, request.UserId, request.RoomId, int(request.Number), after, int(request.Limit))
+	if err != nil {
+		return nil, statusError(err, "failed to get thread")
+	}
+
+	return &chat.GetThreadResponse{
+		Root:    mapToAPIMessage(root),
+		Replies: mapToAPIMessageList(replies).Messages,
+		HasMore: hasMore,
+	}, nil
+}
+
+func (s *ChatServer) AddReaction(ctx context.Context, request *chat.AddReactionRequest) (*chat.AddReactionResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.AddReaction(ctx, request.UserId, request.RoomId, int(request.Number), request.Emoji); err != nil {
+		return nil, statusError(err, "failed to add reaction")
+	}
+
+	return &chat.AddReactionResponse{}, nil
+}
+
+func (s *ChatServer) RemoveReaction(ctx context.Context, request *chat.RemoveReactionRequest) (*chat.RemoveReactionResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.RemoveReaction(ctx, request.UserId, request.RoomId, int(request.Number), request.Emoji); err != nil {
+		return nil, statusError(err, "failed to remove reaction")
+	}
+
+	return &chat.RemoveReactionResponse{}, nil
+}
+
+func (s *ChatServer) GetPresence(ctx context.Context, request *chat.GetPresenceRequest) (*chat.GetPresenceResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	userIDs, err := s.store.GetPresence(ctx, request.UserId, request.RoomId)
+	if err != nil {
+		return nil, statusError(err, "failed to get presence")
+	}
+
+	return &chat.GetPresenceResponse{
+		UserIds: userIDs,
+	}, nil
+}
+
+func (s *ChatServer) GetReadReceipts(ctx context.Context, request *chat.GetReadReceiptsRequest) (*chat.GetReadReceiptsResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	receipts, err := s.store.GetReadReceipts(ctx, request.UserId, request.RoomId)
+	if err != nil {
+		return nil, statusError(err, "failed to get read receipts")
+	}
+
+	return &chat.GetReadReceiptsResponse{
+		Receipts: mapToAPIReadReceipts(receipts),
+	}, nil
+}
+
+func (s *ChatServer) CreateInvite(ctx context.Context, request *chat.CreateInviteRequest) (*chat.CreateInviteResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if request.TtlSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invite ttl can not be negative")
+	}
+
+	if request.MaxUses < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invite max uses can not be negative")
+	}
+
+	ttl := time.Duration(request.TtlSeconds) * time.Second
+	invite, err := s.store.CreateInvite(ctx, request.UserId, request.RoomId, ttl, int(request.MaxUses))
+	if err != nil {
+		return nil, statusError(err, "failed to create invite")
+	}
+
+	if s.isLocal {
+		slog.Info("invite created", "room_id", request.RoomId, "user_id", request.UserId, "expires_at", invite.ExpiresAt)
+	}
+
+	return &chat.CreateInviteResponse{
+		Invite: mapToAPIInvite(invite),
+	}, nil
+}
+
+func (s *ChatServer) RevokeInvite(ctx context.Context, request *chat.RevokeInviteRequest) (*chat.RevokeInviteResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.RevokeInvite(ctx, request.UserId, request.Code); err != nil {
+		return nil, statusError(err, "failed to revoke invite")
+	}
+
+	return &chat.RevokeInviteResponse{}, nil
+}
+
+func (s *ChatServer) JoinWithInvite(ctx context.Context, request *chat.JoinWithInviteRequest) (*chat.JoinWithInviteResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	member, err := s.store.JoinWithInvite(ctx, request.UserId, request.Code)
+	if err != nil {
+		return nil, statusError(err, "failed to join with invite")
+	}
+
+	if s.isLocal {
+		slog.Info("joined with invite", "room_id", member.RoomID, "user_id", request.UserId)
+	}
+
+	return &chat.JoinWithInviteResponse{
+		Member: mapToAPIMember(member),
+	}, nil
+}
+
+func (s *ChatServer) ListInviteUses(ctx context.Context, request *chat.ListInviteUsesRequest) (*chat.ListInviteUsesResponse, error) {
+	if err := checkUser(ctx, request.UserId); err != nil {
+		return nil, err
+	}
+
+	uses, nextPageToken, err := s.store.ListInviteUses(ctx, request.UserId, request.RoomId, int(request.PageSize), request.PageToken)
+	if err != nil {
+		return nil, statusError(err, "failed to list invite uses")
+	}
+
+	return &chat.ListInviteUsesResponse{
+		Uses:          mapToAPIInviteUses(uses),
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
 func (s *ChatServer) Connect(stream chat.ChatService_ConnectServer) error {
 	ctx := stream.Context()
 
@@ -56,65 +508,122 @@ func (s *ChatServer) Connect(stream chat.ChatService_ConnectServer) error {
 
 	connectRoom, ok := in.Payload.(*chat.ConnectRequest_ConnectRoom_)
 	if !ok {
-		return fmt.Errorf("failed to receive message: %w", err)
+		return status.Errorf(codes.InvalidArgument, "first message must be connect room, got %T", in.Payload)
+	}
+
+	if err = checkUser(ctx, connectRoom.ConnectRoom.UserId); err != nil {
+		return err
 	}
 
 	if s.isLocal {
 		slog.Info("connect", "room_id", connectRoom.ConnectRoom.RoomId, "user_id", connectRoom.ConnectRoom.UserId)
 	}
 
-	hub, err := s.store.GetRoomHub(stream.Context(), connectRoom.ConnectRoom.RoomId)
+	hub, connection, err := s.store.ConnectRoom(ctx, connectRoom.ConnectRoom.RoomId, connectRoom.ConnectRoom.UserId, connectRoom.ConnectRoom.LastReadMessageNumber)
 	if err != nil {
-		return fmt.Errorf("failed to get room hub: %w", err)
+		return statusError(err, "failed to connect")
 	}
-
-	connection := hub.Connect(connectRoom.ConnectRoom.UserId, connectRoom.ConnectRoom.LastReadMessageNumber)
 	defer connection.Disconnect()
 
+	if s.isLocal {
+		slog.Info("connected", "room_id", connection.RoomID, "user_id", connection.UserID, "connection_id", connection.ID)
+	}
+
 	if err = stream.Send(&chat.ConnectResponse{
 		Payload: &chat.ConnectResponse_MessageList{
-			MessageList: mapToAPIMessageList(connection.Unread),
+			MessageList: mapToAPIUnreadMessageList(connection.Unread, connection.Gap),
 		},
 	}); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
+	received := make(chan error, 1)
 	go func() {
-		for m := range connection.MessagesCh {
-			if err = stream.Send(&chat.ConnectResponse{
-				Payload: &chat.ConnectResponse_Message{
-					Message: mapToAPIMessage(m),
-				},
-			}); err != nil {
+		received <- s.receive(stream, hub, connection)
+	}()
+
+	for {
+		select {
+		case event, ok := <-connection.EventsCh:
+			if !ok {
+				return statusError(connection.Err(), "connection closed")
+			}
+
+			if dropped := connection.TakeDropped(); dropped > 0 {
+				if err = stream.Send(mapToAPIGap(dropped)); err != nil {
+					return fmt.Errorf("failed to send gap: %w", err)
+				}
+			}
+
+			if err = stream.Send(mapToAPIEvent(event)); err != nil {
 				log.FromContext(ctx).Error("failed to send message", "error", err)
+				return fmt.Errorf("failed to send message: %w", err)
 			}
+		case err = <-received:
+			return err
 		}
-	}()
+	}
+}
+
+// receive handles client requests of the stream until the client closes it or an error occurs.
+func (s *ChatServer) receive(stream chat.ChatService_ConnectServer, hub *RoomHub, connection *Connection) error {
+	ctx := stream.Context()
 
 	for {
-		in, err = stream.Recv()
+		in, err := stream.Recv()
 		switch {
 		case err == io.EOF:
 			return nil
 		case err != nil:
 			return fmt.Errorf("failed to receive message: %w", err)
-		case stream.Context().Err() != nil:
-			return stream.Context().Err()
+		case ctx.Err() != nil:
+			return ctx.Err()
 		}
 
 		switch p := in.Payload.(type) {
 		case *chat.ConnectRequest_SendMessage_:
+			if !connection.Can(PermissionPost) {
+				return statusError(ErrPermissionDenied, "failed to receive message")
+			}
+
 			msg := &Message{
-				UserID:    connection.UserID,
-				RoomID:    connection.RoomID,
-				Text:      p.SendMessage.Text,
-				CreatedAt: time.Now(),
+				UserID: connection.UserID,
+				RoomID: connection.RoomID,
+				Text:   p.SendMessage.Text,
+			}
+			if p.SendMessage.ReplyToNumber != nil {
+				replyTo := int(*p.SendMessage.ReplyToNumber)
+				msg.ReplyTo = &replyTo
 			}
 			if err = hub.ReceiveMessage(ctx, msg); err != nil {
-				return fmt.Errorf("failed to receive message: %w", err)
+				return statusError(err, "failed to receive message")
+			}
+		case *chat.ConnectRequest_MarkRead_:
+			if p.MarkRead.Number < 0 {
+				return status.Error(codes.InvalidArgument, "read message number can not be negative")
+			}
+
+			if err = hub.MarkRead(ctx, connection.UserID, int(p.MarkRead.Number)); err != nil {
+				return statusError(err, "failed to mark read")
+			}
+		case *chat.ConnectRequest_Typing_:
+			// Typing is ephemeral, so users who can not post are ignored rather than disconnected.
+			if connection.Can(PermissionPost) {
+				hub.Typing(connection.UserID, !p.Typing.Stopped)
 			}
 		default:
-			return fmt.Errorf("failed to receive message: %w", err)
+			return status.Errorf(codes.InvalidArgument, "unexpected payload %T", in.Payload)
 		}
 	}
 }
+
+// checkUser rejects requests made on behalf of another user than the authenticated one.
+// Requests are not checked when authentication is disabled.
+func checkUser(ctx context.Context, userID string) error {
+	authenticated, ok := auth.UserFromContext(ctx)
+	if ok && authenticated != userID {
+		return status.Errorf(codes.PermissionDenied, "user %q can not act as %q", authenticated, userID)
+	}
+
+	return nil
+}
@@ -3,111 +3,489 @@ package server
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type RoomHub struct {
-	room  *Room
-	store *Store
-	mx    sync.RWMutex
+	roomID string
+	store  *Store
+	events Subscription
+
+	// mx guards the hub state, events are applied and broadcast under it, so a new connection either
+	// finds a message in messages or receives it. Helpers without a lock of their own expect it held.
+	mx          sync.RWMutex
+	room        *Room
+	closed      bool
+	closeReason error
+	idleSince   time.Time
 
 	lastNumber   int
-	messagesMx   sync.RWMutex
-	messages     []*Message
-	userChannels map[string]chan *Message
+	messages     *messageRing
+	connections  map[string]*Connection
+	userSessions map[string]int
+	typing       map[string]*typingState
+	// shownTyping expires indicators published by any instance.
+	shownTyping map[string]*typingState
+	// typingUpdates is closed with the hub.
+	typingUpdates chan *TypingUpdate
+
+	presenceChanged chan struct{}
+	// presenceDone is closed once trackPresence has taken the users offline.
+	presenceDone chan struct{}
 }
 
+// newRoomHub subscribes before loading the messages, so events published in between are not missed.
 func newRoomHub(ctx context.Context, room *Room, store *Store) (*RoomHub, error) {
+	events, err := store.subscribeRoomEvents(ctx, room.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	messages, lastNumber, err := store.LoadMessages(ctx, room.ID)
 	if err != nil {
+		_ = events.Close()
 		return nil, fmt.Errorf("failed to load messages: %w", err)
 	}
 
-	return &RoomHub{
-		room:         room,
+	maxMessages, _ := store.retentionLimits(room)
+	hub := &RoomHub{
+		roomID:       room.ID,
 		store:        store,
+		events:       events,
+		room:         room,
 		lastNumber:   lastNumber,
-		messages:     messages,
-		userChannels: make(map[string]chan *Message),
-	}, nil
+		idleSince:    time.Now(),
+		messages:     newMessageRing(maxMessages),
+		connections:  make(map[string]*Connection),
+		userSessions: make(map[string]int),
+		typing:       make(map[string]*typingState),
+		shownTyping:  make(map[string]*typingState),
+
+		typingUpdates:   make(chan *TypingUpdate, typingQueueSize),
+		presenceChanged: make(chan struct{}, 1),
+		presenceDone:    make(chan struct{}),
+	}
+
+	for _, message := range messages {
+		hub.messages.push(message)
+	}
+	hub.expireMessages()
+
+	go hub.listen()
+	go hub.trackPresence()
+	go hub.forwardTyping()
+
+	return hub, nil
 }
 
-func (h *RoomHub) Connect(userID string, lastReadMessageNumber int64) *Connection {
+// Connect collects messages after lastReadMessageNumber, marking Gap when some are beyond retention.
+// A negative lastReadMessageNumber gets the in-memory messages.
+func (h *RoomHub) Connect(ctx context.Context, userID string, lastReadMessageNumber int64) (*Connection, error) {
 	h.mx.Lock()
-	defer h.mx.Unlock()
+
+	if h.closed {
+		h.mx.Unlock()
+		return nil, h.closeReason
+	}
+
+	if limit := h.store.maxSessionsPerUser; limit > 0 && h.userSessions[userID] >= limit {
+		h.mx.Unlock()
+		return nil, ErrTooManySessions
+	}
 
 	connection := &Connection{
-		UserID:     userID,
-		RoomID:     h.room.ID,
-		Unread:     h.getUnreadMessages(lastReadMessageNumber),
-		MessagesCh: make(chan *Message, 4),
-		Disconnect: func() {
-			h.disconnect(userID)
-		},
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		RoomID:   h.roomID,
+		EventsCh: make(chan *Event, h.store.delivery.bufferSize),
+		room:     h.room,
 	}
+	connection.Disconnect = func() {
+		h.disconnect(connection)
+	}
+
+	h.connections[connection.ID] = connection
+	if h.userSessions[userID]++; h.userSessions[userID] == 1 {
+		h.notifyPresence()
+	}
+
+	lastRead, lastNumber := int(lastReadMessageNumber), h.lastNumber
+	if lastRead < 0 || lastRead >= lastNumber {
+		connection.Unread = h.getUnreadMessages(lastRead)
+		h.mx.Unlock()
+		return connection, nil
+	}
+
+	from := lastRead + 1
+	if maxMessages, _ := h.store.retentionLimits(h.room); maxMessages > 0 {
+		from = max(from, lastNumber-maxMessages+1)
+	}
+
+	unread := h.getUnreadMessages(from - 1)
+	h.mx.Unlock()
+
+	// Messages before the in-memory window were saved before the connection was registered,
+	// so they can be loaded without holding the lock.
+	to := lastNumber
+	if len(unread) > 0 {
+		to = unread[0].Number - 1
+	}
+
+	if from <= to {
+		older, err := h.store.LoadMessageRange(ctx, connection.RoomID, from, to)
+		if err != nil {
+			connection.Disconnect()
+			return nil, fmt.Errorf("failed to load unread messages: %w", err)
+		}
 
-	h.userChannels[userID] = connection.MessagesCh
+		unread = append(older, unread...)
+	}
 
-	return connection
+	connection.Unread = unread
+	connection.Gap = len(unread) == 0 || unread[0].Number > lastRead+1
+
+	return connection, nil
 }
 
+// ReceiveMessage saves the message, connections receive it from the room events channel.
 func (h *RoomHub) ReceiveMessage(ctx context.Context, message *Message) error {
-	if err := h.saveMessage(ctx, message); err != nil {
+	h.mx.RLock()
+	closed, archived := h.closed, h.room.Archived
+	var parent *Message
+	if message.ReplyTo != nil {
+		parent = h.messages.get(*message.ReplyTo)
+	}
+	h.mx.RUnlock()
+
+	switch {
+	case closed:
+		return ErrRoomDeleted
+	case archived:
+		return ErrRoomArchived
+	}
+
+	if message.ReplyTo != nil {
+		if err := h.joinThread(ctx, message, parent); err != nil {
+			return err
+		}
+	}
+
+	message.CreatedAt = time.Now()
+	if err := h.store.SaveMessage(ctx, message); err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 
+	return nil
+}
+
+// joinThread points the reply to the root of the thread of its parent.
+func (h *RoomHub) joinThread(ctx context.Context, message, parent *Message) error {
+	if parent == nil {
+		var err error
+		if parent, err = h.store.loadMessage(ctx, h.roomID, *message.ReplyTo); err != nil {
+			return err
+		}
+	}
+
+	if parent.Deleted() {
+		return ErrMessageDeleted
+	}
+
+	if parent.ReplyTo != nil {
+		message.ReplyTo = parent.ReplyTo
+	}
+
+	return nil
+}
+
+func (h *RoomHub) listen() {
+	for event := range h.events.Events() {
+		switch event.Type {
+		case RoomEventMessage:
+			h.applyMessage(event.Message, event.ReplyCount)
+		case RoomEventMessageEdited:
+			h.replaceMessage(event.Message, &Event{Edited: event.Message})
+		case RoomEventMessageDeleted:
+			h.replaceMessage(event.Message, &Event{Deleted: event.Message})
+		case RoomEventReaction:
+			h.applyReaction(event.Reaction)
+		case RoomEventPresence:
+			h.applyPresence(event.Presence)
+		case RoomEventReadReceipt:
+			h.applyReadReceipt(event.ReadReceipt)
+		case RoomEventTyping:
+			h.applyTyping(event.Typing)
+		case RoomEventRoomUpdated:
+			h.updateRoom(event.Room)
+		case RoomEventRoomDeleted:
+			h.store.unloadRoomHub(h, ErrRoomDeleted)
+		case RoomEventMemberRemoved:
+			h.removeMember(event.Member.UserID)
+		case RoomEventMemberUpdated:
+			h.updateMember(event.Member)
+		}
+	}
+}
+
+// applyMessage loads messages missed by the subscription first.
+func (h *RoomHub) applyMessage(message *Message, replyCount int) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed || message.Number <= h.lastNumber {
+		return
+	}
+
+	if message.Number > h.lastNumber+1 {
+		missed, err := h.store.LoadMessageRange(context.Background(), h.roomID, h.lastNumber+1, message.Number-1)
+		if err != nil {
+			slog.Error("failed to load missed messages", "room_id", h.roomID, "error", err)
+		}
+
+		for _, m := range missed {
+			h.appendMessage(m)
+		}
+	}
+
+	h.appendMessage(message)
+
+	if h.typing[message.UserID] != nil {
+		h.stopTyping(message.UserID)
+	}
+
+	if message.ReplyTo != nil {
+		h.updateThread(*message.ReplyTo, replyCount)
+	}
+}
+
+func (h *RoomHub) updateThread(number, replyCount int) {
+	if root := h.messages.get(number); root != nil {
+		updated := *root
+		updated.ReplyCount = replyCount
+		h.messages.replace(&updated)
+	}
+
+	h.broadcast(&Event{Thread: &ThreadUpdate{Number: number, ReplyCount: replyCount}})
+}
+
+func (h *RoomHub) appendMessage(message *Message) {
+	h.messages.push(message)
+	h.expireMessages()
+	h.lastNumber = message.Number
+	h.broadcast(&Event{Message: message})
+}
+
+// replaceMessage skips messages not applied yet, they are loaded with the change.
+func (h *RoomHub) replaceMessage(message *Message, event *Event) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed || message.Number > h.lastNumber {
+		return
+	}
+
+	h.messages.replace(message)
+	h.broadcast(event)
+}
+
+func (h *RoomHub) applyReaction(update *ReactionUpdate) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed || update.Number > h.lastNumber {
+		return
+	}
+
+	if message := h.messages.get(update.Number); message != nil {
+		updated := *message
+		updated.Reactions = withReaction(message.Reactions, update.Emoji, update.UserID, update.Added)
+		h.messages.replace(&updated)
+	}
+
+	h.broadcast(&Event{Reaction: update})
+}
+
+func (h *RoomHub) expireMessages() {
+	if _, maxRetention := h.store.retentionLimits(h.room); maxRetention > 0 {
+		h.messages.expire(time.Now().Add(-maxRetention))
+	}
+}
+
+func (h *RoomHub) updateRoom(room *Room) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.room = room
+	if maxMessages, _ := h.store.retentionLimits(room); maxMessages != h.messages.capacity {
+		h.messages.resize(maxMessages)
+	}
+	h.expireMessages()
+	h.broadcast(&Event{Room: room})
+}
+
+func (h *RoomHub) removeMember(userID string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for _, connection := range h.connections {
+		if connection.UserID == userID {
+			h.kick(connection, ErrNotMember)
+		}
+	}
+}
+
+func (h *RoomHub) updateMember(member *Member) {
 	h.mx.RLock()
 	defer h.mx.RUnlock()
 
-	h.messages = append(h.messages, message)
+	role := roomRole(h.room, member.UserID, member)
+	for _, connection := range h.connections {
+		if connection.UserID == member.UserID {
+			connection.role.Store(&role)
+		}
+	}
+}
+
+func (h *RoomHub) close(reason error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
 
-	for _, ch := range h.userChannels {
-		ch <- message
+	if h.closed {
+		return
 	}
 
-	return nil
+	h.closed, h.closeReason = true, reason
+	for _, connection := range h.connections {
+		h.kick(connection, reason)
+	}
+
+	for _, state := range h.typing {
+		state.timer.Stop()
+	}
+	for _, state := range h.shownTyping {
+		state.timer.Stop()
+	}
+
+	h.notifyPresence()
+	close(h.typingUpdates)
+	h.closeEvents()
 }
 
-func (h *RoomHub) saveMessage(ctx context.Context, message *Message) error {
-	h.messagesMx.Lock()
-	defer h.messagesMx.Unlock()
+// closeIfIdle leaves the room events subscription open, the caller closes it once the hub is unloaded.
+func (h *RoomHub) closeIfIdle(idleTTL time.Duration) bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
 
-	message.Number = h.lastNumber + 1
-	if err := h.store.SaveMessage(ctx, message); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+	if h.closed || len(h.connections) > 0 || time.Since(h.idleSince) < idleTTL {
+		return false
 	}
 
-	h.messages = append(h.messages, message)
-	h.lastNumber++
+	h.closed, h.closeReason = true, errHubEvicted
+	h.notifyPresence()
+	close(h.typingUpdates)
+	return true
+}
 
-	return nil
+func (h *RoomHub) closeEvents() {
+	if err := h.events.Close(); err != nil {
+		slog.Error("failed to close room events subscription", "room_id", h.roomID, "error", err)
+	}
+}
+
+func (h *RoomHub) broadcast(event *Event) {
+	deadline := &deliveryDeadline{timeout: h.store.delivery.timeout}
+	defer deadline.stop()
+
+	for _, connection := range h.connections {
+		h.deliver(connection, event, deadline)
+	}
+}
+
+func (h *RoomHub) kick(connection *Connection, reason error) {
+	connection.err = reason
+	h.remove(connection)
 }
 
-func (h *RoomHub) getUnreadMessages(lastReadMessageNumber int64) []*Message {
-	if lastReadMessageNumber <= -1 {
-		return h.messages
+func (h *RoomHub) remove(connection *Connection) {
+	close(connection.EventsCh)
+	delete(h.connections, connection.ID)
+
+	if h.userSessions[connection.UserID]--; h.userSessions[connection.UserID] <= 0 {
+		delete(h.userSessions, connection.UserID)
+		h.notifyPresence()
+
+		if h.typing[connection.UserID] != nil {
+			h.stopTyping(connection.UserID)
+		}
 	}
 
-	i := len(h.messages) - 1
+	if len(h.connections) == 0 {
+		h.idleSince = time.Now()
+	}
+}
 
-	return h.messages[i+1:]
+// getUnreadMessages expires messages, so h.mx must be held for writing.
+func (h *RoomHub) getUnreadMessages(lastReadMessageNumber int) []*Message {
+	h.expireMessages()
+	return h.messages.after(lastReadMessageNumber)
 }
 
-func (h *RoomHub) disconnect(userID string) {
+func (h *RoomHub) disconnect(connection *Connection) {
 	h.mx.Lock()
 	defer h.mx.Unlock()
 
-	ch := h.userChannels[userID]
-	if ch != nil {
-		close(ch)
-		delete(h.userChannels, userID)
+	// The connection could have been already kicked by the hub.
+	if h.connections[connection.ID] != nil {
+		h.remove(connection)
 	}
 }
 
+// Connection is a single session of a user in the room, a user can have several of them at once.
 type Connection struct {
+	ID         string
 	UserID     string
 	RoomID     string
 	Unread     []*Message
-	MessagesCh chan *Message
+	Gap        bool
+	EventsCh   chan *Event
 	Disconnect func()
+
+	room *Room
+	// err is set by the hub before it closes EventsCh on its own.
+	err error
+	// dropped counts events dropped by the drop-oldest slow consumer policy.
+	dropped atomic.Int64
+	// role is nil until the connection is authorized, it is replaced by the hub when the role changes.
+	role atomic.Pointer[Role]
+}
+
+// Can reports whether the role of the connected user has the permissions.
+func (c *Connection) Can(permissions Permission) bool {
+	role := c.role.Load()
+	return role != nil && role.can(permissions)
+}
+
+// authorize sets the role read once the connection was registered, unless the hub
+// has already applied a newer role from a room event.
+func (c *Connection) authorize(role Role) {
+	c.role.CompareAndSwap(nil, &role)
+}
+
+// TakeDropped returns the number of events dropped since the previous call.
+func (c *Connection) TakeDropped() int64 {
+	return c.dropped.Swap(0)
+}
+
+// Err returns the reason the hub closed the connection, it is only valid after EventsCh is closed.
+func (c *Connection) Err() error {
+	return c.err
 }
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/DavidMovas/chat-rooms/internal/metrics"
+)
+
+// RunHubEviction unloads room hubs nobody has been connected to for idleTTL until ctx is done.
+// Evicted hubs are loaded again on the next connection to their room.
+func (s *Store) RunHubEviction(ctx context.Context, idleTTL time.Duration) {
+	ticker := time.NewTicker(max(idleTTL/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if evicted := s.EvictIdleHubs(idleTTL); evicted > 0 {
+			slog.Debug("idle room hubs evicted", "hubs", evicted, "loaded_hubs", s.LoadedHubs())
+		}
+	}
+}
+
+// EvictIdleHubs unloads room hubs without connections for at least idleTTL and returns their number.
+func (s *Store) EvictIdleHubs(idleTTL time.Duration) int {
+	var evicted []*RoomHub
+
+	s.roomHubMx.Lock()
+	for roomID, hub := range s.roomHub {
+		if hub.closeIfIdle(idleTTL) {
+			delete(s.roomHub, roomID)
+			evicted = append(evicted, hub)
+		}
+	}
+	s.roomHubMx.Unlock()
+
+	metrics.LoadedHubs.Add(-int64(len(evicted)))
+	for _, hub := range evicted {
+		hub.closeEvents()
+	}
+
+	return len(evicted)
+}
+
+// LoadedHubs returns the number of room hubs loaded by this instance.
+func (s *Store) LoadedHubs() int {
+	s.roomHubMx.RLock()
+	defer s.roomHubMx.RUnlock()
+
+	return len(s.roomHub)
+}
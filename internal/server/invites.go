@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invite lets users join a room, including a private one, without being added by a moderator.
+type Invite struct {
+	Code      string
+	RoomID    string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// MaxUses limits how many users can join with the invite, 0 means no limit.
+	MaxUses int
+	Uses    int
+}
+
+// InviteUse is the audit record of a user who joined a room with an invite.
+type InviteUse struct {
+	Code      string
+	RoomID    string
+	UserID    string
+	CreatedBy string
+	UsedAt    time.Time
+}
+
+// CreateInvite creates an invite to the room valid for ttl, or for the configured invite TTL when
+// ttl is 0. Only users allowed to manage members can create invites.
+func (s *Store) CreateInvite(ctx context.Context, userID, roomID string, ttl time.Duration, maxUses int) (*Invite, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkPermission(ctx, room, userID, PermissionManageMembers); err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = s.inviteTTL
+	}
+
+	now := time.Now()
+	invite := &Invite{
+		Code:      uuid.New().String(),
+		RoomID:    roomID,
+		CreatedBy: userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		MaxUses:   maxUses,
+	}
+
+	if err = s.storage.CreateInvite(ctx, invite); err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// RevokeInvite deletes the invite, users allowed to manage members of its room can revoke it.
+func (s *Store) RevokeInvite(ctx context.Context, userID, code string) error {
+	invite, err := s.storage.GetInvite(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if invite == nil {
+		return ErrInviteNotFound
+	}
+
+	room, err := s.loadRoom(ctx, invite.RoomID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.checkPermission(ctx, room, userID, PermissionManageMembers); err != nil {
+		return err
+	}
+
+	deleted, err := s.storage.DeleteInvite(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// JoinWithInvite adds the user to the room of the invite. Members joining again keep their
+// membership and do not use the invite up.
+func (s *Store) JoinWithInvite(ctx context.Context, userID, code string) (*Member, error) {
+	invite, err := s.storage.GetInvite(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if invite == nil {
+		return nil, ErrInviteNotFound
+	}
+
+	// Invites do not lift bans, users restricted to read-only join read-only.
+	restriction, err := s.storage.GetRestriction(ctx, invite.RoomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if restriction == RestrictionBanned {
+		return nil, ErrPermissionDenied
+	}
+
+	role := RoleMember
+	if restriction == RestrictionReadOnly {
+		role = RoleReadOnly
+	}
+
+	now := time.Now()
+	member, _, err := s.storage.JoinWithInvite(ctx, code, userID, func(invite *Invite) (*Member, error) {
+		switch {
+		case !now.Before(invite.ExpiresAt):
+			return nil, ErrInviteExpired
+		case invite.MaxUses > 0 && invite.Uses >= invite.MaxUses:
+			return nil, ErrInviteUsedUp
+		}
+
+		return &Member{
+			RoomID:   invite.RoomID,
+			UserID:   userID,
+			JoinedAt: now,
+			Role:     role,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// ListInviteUses returns the audit of users who joined the room with invites, from the oldest.
+// Only users allowed to manage members can read it.
+func (s *Store) ListInviteUses(ctx context.Context, userID, roomID string, pageSize int, pageToken string) (uses []*InviteUse, nextPageToken string, err error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = s.checkPermission(ctx, room, userID, PermissionManageMembers); err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	return s.storage.ListInviteUses(ctx, roomID, pageSize, pageToken)
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// JoinRoom adds memberID to the room, or userID when memberID is empty, and returns the membership.
+// Users can join public rooms on their own, only users allowed to manage members can add other
+// users and members of private rooms. Joining a room twice returns the existing membership.
+func (s *Store) JoinRoom(ctx context.Context, userID, roomID, memberID string) (*Member, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if memberID == "" {
+		memberID = userID
+	}
+
+	if memberID != userID || room.Private {
+		if err = s.checkPermission(ctx, room, userID, PermissionManageMembers); err != nil {
+			return nil, err
+		}
+	}
+
+	restriction, err := s.storage.GetRestriction(ctx, roomID, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Banned users can not join on their own, being added by a user allowed to manage members lifts the ban.
+	if restriction == RestrictionBanned {
+		if memberID == userID {
+			return nil, ErrPermissionDenied
+		}
+
+		if err = s.storage.SetRestriction(ctx, roomID, memberID, RestrictionNone); err != nil {
+			return nil, err
+		}
+	}
+
+	member := &Member{
+		RoomID:   roomID,
+		UserID:   memberID,
+		JoinedAt: time.Now(),
+		Role:     RoleMember,
+	}
+	if restriction == RestrictionReadOnly {
+		member.Role = RoleReadOnly
+	}
+
+	added, err := s.storage.AddMember(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+
+	if !added {
+		return s.storage.GetMember(ctx, roomID, memberID)
+	}
+
+	return member, nil
+}
+
+// LeaveRoom removes the user from the room, the owner can not leave it.
+func (s *Store) LeaveRoom(ctx context.Context, userID, roomID string) error {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	return s.removeMember(ctx, room, userID)
+}
+
+// KickMember bans memberID from the room and removes the membership, connections of the user are closed.
+// Users of public rooms can be kicked without being members. Users allowed to manage members can only
+// kick members ranked below them.
+func (s *Store) KickMember(ctx context.Context, userID, roomID, memberID string) error {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	role, err := s.roleOf(ctx, room, userID)
+	if err != nil {
+		return err
+	}
+
+	if !role.can(PermissionManageMembers) {
+		return ErrPermissionDenied
+	}
+
+	// The owner is left to removeMember, which refuses to remove it.
+	if memberID != room.OwnerID {
+		member, err := s.storage.GetMember(ctx, roomID, memberID)
+		if err != nil {
+			return err
+		}
+
+		if member != nil && !role.outranks(roomRole(room, memberID, member)) {
+			return ErrPermissionDenied
+		}
+
+		if member == nil && room.Private {
+			return ErrNotMember
+		}
+
+		// The ban is stored first, so the user can not connect again before the membership is removed.
+		if err = s.storage.SetRestriction(ctx, roomID, memberID, RestrictionBanned); err != nil {
+			return err
+		}
+
+		if member == nil {
+			return nil
+		}
+	}
+
+	return s.removeMember(ctx, room, memberID)
+}
+
+// GrantRole changes the role of memberID in the room. Users allowed to manage roles can only grant
+// roles ranked below their own to members ranked below them, the owner role can not be granted.
+func (s *Store) GrantRole(ctx context.Context, userID, roomID, memberID string, role Role) (*Member, error) {
+	if !role.valid() || role == RoleOwner {
+		return nil, ErrInvalidRole
+	}
+
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	granter, err := s.roleOf(ctx, room, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !granter.can(PermissionManageRoles) || !granter.outranks(role) {
+		return nil, ErrPermissionDenied
+	}
+
+	member, err := s.storage.UpdateMember(ctx, roomID, memberID, func(member *Member) error {
+		if !granter.outranks(roomRole(room, memberID, member)) {
+			return ErrPermissionDenied
+		}
+
+		member.Role = role
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.storage.SetRestriction(ctx, roomID, memberID, restrictionOf(role)); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// RevokeRole resets the role of memberID in the room to RoleMember.
+func (s *Store) RevokeRole(ctx context.Context, userID, roomID, memberID string) (*Member, error) {
+	return s.GrantRole(ctx, userID, roomID, memberID, RoleMember)
+}
+
+// ListMembers returns room members ordered by user ID, only members can list members of a private room.
+func (s *Store) ListMembers(ctx context.Context, userID, roomID string, pageSize int, pageToken string) (members []*Member, nextPageToken string, err error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	return s.storage.ListMembers(ctx, roomID, pageSize, pageToken)
+}
+
+func (s *Store) removeMember(ctx context.Context, room *Room, memberID string) error {
+	if room.OwnerID == memberID {
+		return ErrOwnerCannotLeave
+	}
+
+	removed, err := s.storage.RemoveMember(ctx, room.ID, memberID)
+	if err != nil {
+		return err
+	}
+
+	if !removed {
+		return ErrNotMember
+	}
+
+	return nil
+}
+
+// checkAccess allows anyone into public rooms and only members into private ones. The owner
+// is always allowed, even for rooms created before owners were stored as members.
+func (s *Store) checkAccess(ctx context.Context, room *Room, userID string) error {
+	_, err := s.accessRole(ctx, room, userID)
+	return err
+}
+
+// accessRole returns the role of the user in the room or ErrNotMember when the user has no access.
+func (s *Store) accessRole(ctx context.Context, room *Room, userID string) (Role, error) {
+	role, err := s.roleOf(ctx, room, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if role == "" {
+		return "", ErrNotMember
+	}
+
+	return role, nil
+}
+
+// checkPermission returns ErrPermissionDenied unless the role of the user in the room has the permissions.
+func (s *Store) checkPermission(ctx context.Context, room *Room, userID string, permissions Permission) error {
+	role, err := s.roleOf(ctx, room, userID)
+	if err != nil {
+		return err
+	}
+
+	if !role.can(permissions) {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// roleOf returns the role of the user in the room, it is empty for non-members of private rooms.
+func (s *Store) roleOf(ctx context.Context, room *Room, userID string) (Role, error) {
+	if room.OwnerID == userID {
+		return RoleOwner, nil
+	}
+
+	member, err := s.storage.GetMember(ctx, room.ID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	restriction, err := s.storage.GetRestriction(ctx, room.ID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return restriction.limit(roomRole(room, userID, member)), nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// EditMessage replaces the text of a message written by userID, the previous text is kept as a revision.
+// Connections of all server instances receive the edited message.
+func (s *Store) EditMessage(ctx context.Context, userID, roomID string, number int, text string) (*Message, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if room.Archived {
+		return nil, ErrRoomArchived
+	}
+
+	if err = s.checkPermission(ctx, room, userID, PermissionPost); err != nil {
+		return nil, err
+	}
+
+	message, err := s.loadMessage(ctx, roomID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.UserID != userID {
+		return nil, ErrPermissionDenied
+	}
+
+	if message.Deleted() {
+		return nil, ErrMessageDeleted
+	}
+
+	message.Text, message.EditedAt = text, time.Now()
+	if err = s.storage.EditMessage(ctx, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessage replaces a message with a tombstone that keeps its number, so message numbers stay
+// gapless. Authors can delete their messages, users allowed to delete messages can delete any of them,
+// also in archived rooms. Connections of all server instances receive the tombstone.
+func (s *Store) DeleteMessage(ctx context.Context, userID, roomID string, number int) (*Message, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := s.accessRole(ctx, room, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.loadMessage(ctx, roomID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.UserID != userID && !role.can(PermissionDeleteMessages) {
+		return nil, ErrPermissionDenied
+	}
+
+	if message.Deleted() {
+		return nil, ErrMessageDeleted
+	}
+
+	deleted := tombstone(message, &Message{DeletedAt: time.Now(), DeletedBy: userID})
+	if err = s.storage.DeleteMessage(ctx, deleted); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// GetThread returns the root message of a thread with up to limit of its replies numbered after
+// the given number, or the oldest ones when after is nil.
+func (s *Store) GetThread(ctx context.Context, userID, roomID string, number int, after *int, limit int) (root *Message, replies []*Message, hasMore bool, err error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, nil, false, err
+	}
+
+	if root, err = s.loadMessage(ctx, roomID, number); err != nil {
+		return nil, nil, false, err
+	}
+
+	switch {
+	case limit <= 0:
+		limit = defaultPageSize
+	case limit > maxPageSize:
+		limit = maxPageSize
+	}
+
+	from := number
+	if after != nil {
+		from = max(from, *after)
+	}
+
+	replies, err = s.storage.LoadThread(ctx, roomID, number, from, limit+1)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if len(replies) > limit {
+		replies, hasMore = replies[:limit], true
+	}
+
+	return root, s.retained(replies), hasMore, nil
+}
+
+// GetMessageRevisions returns the previous texts of a message from the oldest one, deleted messages have none.
+func (s *Store) GetMessageRevisions(ctx context.Context, userID, roomID string, number int) ([]*MessageRevision, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	if _, err = s.loadMessage(ctx, roomID, number); err != nil {
+		return nil, err
+	}
+
+	return s.storage.LoadMessageRevisions(ctx, roomID, number)
+}
+
+// loadMessage returns ErrMessageNotFound for messages that are not stored or beyond retention.
+func (s *Store) loadMessage(ctx context.Context, roomID string, number int) (*Message, error) {
+	messages, err := s.loadMessageRange(ctx, roomID, number, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	return messages[0], nil
+}
@@ -17,6 +17,29 @@ type Message struct {
 	UserID    string
 	Text      string
 	CreatedAt time.Time
+	// ReplyTo is the number of the thread root for replies, replies to replies join the thread of their parent.
+	ReplyTo *int
+	// ReplyCount is the number of replies in the thread of a root message.
+	ReplyCount int
+	// Reactions are ordered by emoji, tombstones have none.
+	Reactions []*Reaction
+	// EditedAt is when Text was last changed by the author, it is zero for messages never edited.
+	EditedAt time.Time
+	// DeletedAt is set for tombstones of deleted messages, they keep the number but not the text.
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+// Deleted reports whether the message is a tombstone.
+func (m *Message) Deleted() bool {
+	return !m.DeletedAt.IsZero()
+}
+
+// MessageRevision is a previous text of an edited message.
+type MessageRevision struct {
+	Text string
+	// CreatedAt is when the text was written, the creation or the edit time of the message.
+	CreatedAt time.Time
 }
 
 func (m *Message) MarshalBinary() (data []byte, err error) {
@@ -26,3 +49,26 @@ func (m *Message) MarshalBinary() (data []byte, err error) {
 func (m *Message) UnmarshalBinary(data []byte) error {
 	return json.Unmarshal(data, m)
 }
+
+// Event is delivered to room connections, exactly one of its fields is set.
+type Event struct {
+	Message *Message
+	// Edited is a message already delivered whose text has changed.
+	Edited *Message
+	// Deleted is the tombstone of a message already delivered.
+	Deleted *Message
+	// Thread is sent when a reply is added to a thread.
+	Thread *ThreadUpdate
+	// Reaction is sent when a user reacts to a message or takes the reaction back.
+	Reaction    *ReactionUpdate
+	Typing      *TypingUpdate
+	Presence    *PresenceUpdate
+	ReadReceipt *ReadReceipt
+	Room        *Room
+}
+
+// ThreadUpdate is the new reply count of a thread, Number is the number of its root message.
+type ThreadUpdate struct {
+	Number     int
+	ReplyCount int
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultPresenceTTL = 30 * time.Second
+	// presenceTimeout bounds a single presence update of the storage.
+	presenceTimeout = 5 * time.Second
+)
+
+// PresenceUpdate tells connections that a user connected to the room through any server instance
+// or that the last session of the user left.
+type PresenceUpdate struct {
+	UserID string
+	Online bool
+}
+
+// GetPresence returns IDs of users connected to the room through any server instance, ordered by ID.
+// Only members can see who is in a private room.
+func (s *Store) GetPresence(ctx context.Context, userID, roomID string) ([]string, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.ListPresence(ctx, roomID)
+}
+
+// trackPresence stores the users connected to the hub whenever one of them comes or goes and refreshes
+// them as a heartbeat, so they go offline once this instance stops refreshing them. The users are taken
+// offline when the hub is closed.
+func (h *RoomHub) trackPresence() {
+	defer close(h.presenceDone)
+
+	ticker := time.NewTicker(max(h.store.presenceTTL/3, time.Millisecond))
+	defer ticker.Stop()
+
+	var stored bool
+	for {
+		select {
+		case <-h.presenceChanged:
+		case <-ticker.C:
+		}
+
+		h.mx.RLock()
+		closed := h.closed
+		userIDs := make([]string, 0, len(h.userSessions))
+		if !closed {
+			for userID := range h.userSessions {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		h.mx.RUnlock()
+
+		// Idle hubs have nobody to refresh.
+		if stored || len(userIDs) > 0 {
+			h.storePresence(userIDs)
+			stored = len(userIDs) > 0
+		}
+
+		if closed {
+			return
+		}
+	}
+}
+
+func (h *RoomHub) storePresence(userIDs []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+
+	if err := h.store.storage.SetPresence(ctx, h.roomID, h.store.instanceID, userIDs, h.store.presenceTTL); err != nil {
+		slog.Error("failed to store presence", "room_id", h.roomID, "error", err)
+	}
+}
+
+// notifyPresence wakes trackPresence up without waiting for it, it must be called with h.mx held.
+func (h *RoomHub) notifyPresence() {
+	select {
+	case h.presenceChanged <- struct{}{}:
+	default:
+	}
+}
+
+// applyPresence broadcasts users coming online or going offline in any server instance. The updates are
+// advisory, connections that fall behind miss them and can catch up with GetPresence.
+func (h *RoomHub) applyPresence(updates []*PresenceUpdate) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	for _, update := range updates {
+		event := &Event{Presence: update}
+		for _, connection := range h.connections {
+			h.offer(connection, event)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"unicode/utf8"
+)
+
+// maxEmojiLength is in bytes, it fits emoji sequences such as flags and families.
+const maxEmojiLength = 32
+
+// Reaction aggregates the users who reacted to a message with the same emoji.
+type Reaction struct {
+	Emoji string
+	// UserIDs are ordered by ID, the reaction count is their number.
+	UserIDs []string
+}
+
+// ReactionUpdate is a change of the reactions of a message, Count is the number of users who reacted
+// with the emoji after the change.
+type ReactionUpdate struct {
+	Number int
+	Emoji  string
+	UserID string
+	Added  bool
+	Count  int
+}
+
+// AddReaction adds the reaction of the user to a message, users allowed to post can react.
+// Reacting twice with the same emoji has no effect.
+func (s *Store) AddReaction(ctx context.Context, userID, roomID string, number int, emoji string) error {
+	if !validEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	if room.Archived {
+		return ErrRoomArchived
+	}
+
+	if err = s.checkPermission(ctx, room, userID, PermissionPost); err != nil {
+		return err
+	}
+
+	message, err := s.loadMessage(ctx, roomID, number)
+	if err != nil {
+		return err
+	}
+
+	if message.Deleted() {
+		return ErrMessageDeleted
+	}
+
+	_, err = s.storage.AddReaction(ctx, roomID, number, emoji, userID)
+	return err
+}
+
+// RemoveReaction removes the reaction of the user from a message, removing a missing reaction has no effect.
+func (s *Store) RemoveReaction(ctx context.Context, userID, roomID string, number int, emoji string) error {
+	if !validEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return err
+	}
+
+	if _, err = s.loadMessage(ctx, roomID, number); err != nil {
+		return err
+	}
+
+	_, err = s.storage.RemoveReaction(ctx, roomID, number, emoji, userID)
+	return err
+}
+
+func validEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= maxEmojiLength && utf8.ValidString(emoji)
+}
+
+// withReaction returns a copy of reactions with the user added to or removed from the emoji reaction.
+// Reactions are ordered by emoji, those left without users are dropped. The reactions are not modified,
+// so messages sharing them stay intact.
+func withReaction(reactions []*Reaction, emoji, userID string, added bool) []*Reaction {
+	i := sort.Search(len(reactions), func(i int) bool {
+		return reactions[i].Emoji >= emoji
+	})
+
+	var userIDs []string
+	if i < len(reactions) && reactions[i].Emoji == emoji {
+		userIDs = reactions[i].UserIDs
+		reactions = slices.Delete(slices.Clone(reactions), i, i+1)
+	} else {
+		reactions = slices.Clone(reactions)
+	}
+
+	j, found := slices.BinarySearch(userIDs, userID)
+	switch {
+	case added && !found:
+		userIDs = slices.Insert(slices.Clone(userIDs), j, userID)
+	case !added && found:
+		userIDs = slices.Delete(slices.Clone(userIDs), j, j+1)
+	}
+
+	if len(userIDs) == 0 {
+		return reactions
+	}
+
+	return slices.Insert(reactions, i, &Reaction{Emoji: emoji, UserIDs: userIDs})
+}
+
+// reactionCount returns the number of users who reacted with the emoji.
+func reactionCount(reactions []*Reaction, emoji string) int {
+	for _, reaction := range reactions {
+		if reaction.Emoji == emoji {
+			return len(reaction.UserIDs)
+		}
+	}
+
+	return 0
+}
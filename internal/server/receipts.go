@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReadReceipt is the read cursor of a user in the room, Number is the last message the user has read.
+type ReadReceipt struct {
+	UserID string
+	Number int
+	ReadAt time.Time
+}
+
+// GetReadReceipts returns the read cursors of the room ordered by user ID, only members can read
+// them in private rooms.
+func (s *Store) GetReadReceipts(ctx context.Context, userID, roomID string) ([]*ReadReceipt, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.ListReadReceipts(ctx, roomID)
+}
+
+// lastReadMessageNumber returns the requested number, or the read cursor of the user when it is nil.
+// Users who have never marked the room read get -1.
+func (s *Store) lastReadMessageNumber(ctx context.Context, roomID, userID string, requested *int64) (int64, error) {
+	if requested != nil {
+		return *requested, nil
+	}
+
+	receipt, err := s.storage.GetReadReceipt(ctx, roomID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get read cursor: %w", err)
+	}
+
+	if receipt == nil {
+		return -1, nil
+	}
+
+	return int64(receipt.Number), nil
+}
+
+// MarkRead moves the read cursor of the user forward to the message number, numbers beyond the last
+// message of the room are capped to it. The receipt is delivered to connections once its event comes
+// back from the room events channel.
+func (h *RoomHub) MarkRead(ctx context.Context, userID string, number int) error {
+	h.mx.RLock()
+	closed, lastNumber := h.closed, h.lastNumber
+	h.mx.RUnlock()
+
+	if closed {
+		return ErrRoomDeleted
+	}
+
+	receipt := &ReadReceipt{
+		UserID: userID,
+		Number: min(number, lastNumber),
+		ReadAt: time.Now(),
+	}
+
+	// Nothing has been read in an empty room.
+	if receipt.Number < 0 {
+		return nil
+	}
+
+	if _, err := h.store.storage.MarkRead(ctx, h.roomID, receipt); err != nil {
+		return fmt.Errorf("failed to mark read: %w", err)
+	}
+
+	return nil
+}
+
+// applyReadReceipt broadcasts the moved read cursor, including to the other sessions of its user.
+func (h *RoomHub) applyReadReceipt(receipt *ReadReceipt) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	h.broadcast(&Event{ReadReceipt: receipt})
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/DavidMovas/chat-rooms/internal/metrics"
+)
+
+const retentionLease = "retention"
+
+// RetentionReport summarizes a single retention run.
+type RetentionReport struct {
+	Rooms        int
+	TrimmedRooms int
+	Messages     int
+	// Invites is the number of expired invites removed.
+	Invites int
+}
+
+// RunRetention enforces retention every interval until ctx is done. Server instances sharing
+// the storage take turns, a run is skipped while another instance holds the retention lease.
+// Trimming is idempotent, so overlapping runs remove nothing twice.
+func (s *Store) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		acquired, err := s.storage.AcquireLease(ctx, retentionLease, interval)
+		if err != nil {
+			slog.Error("failed to acquire retention lease", "error", err)
+			continue
+		}
+
+		if !acquired {
+			metrics.Retention.Add("skipped_runs", 1)
+			continue
+		}
+
+		report, err := s.EnforceRetention(ctx)
+		if err != nil {
+			slog.Error("failed to enforce retention", "error", err)
+		}
+
+		slog.Info("retention enforced", "rooms", report.Rooms, "trimmed_rooms", report.TrimmedRooms, "messages", report.Messages,
+			"invites", report.Invites)
+	}
+}
+
+// EnforceRetention removes messages beyond the retention limits and expired invites from every room.
+// The stricter of the server limits and the room overrides applies.
+func (s *Store) EnforceRetention(ctx context.Context) (*RetentionReport, error) {
+	report := &RetentionReport{}
+	defer func() {
+		metrics.Retention.Add("runs", 1)
+		metrics.Retention.Add("trimmed_rooms", int64(report.TrimmedRooms))
+		metrics.Retention.Add("removed_messages", int64(report.Messages))
+		metrics.Retention.Add("removed_invites", int64(report.Invites))
+	}()
+
+	var pageToken string
+	for {
+		rooms, nextPageToken, err := s.storage.ListRooms(ctx, RoomFilter{}, maxPageSize, pageToken)
+		if err != nil {
+			return report, fmt.Errorf("failed to list rooms: %w", err)
+		}
+
+		for _, room := range rooms {
+			maxMessages, maxRetention := s.retentionLimits(room.Room)
+
+			var createdBefore time.Time
+			if maxRetention > 0 {
+				createdBefore = time.Now().Add(-maxRetention)
+			}
+
+			removed, err := s.storage.TrimMessages(ctx, room.ID, maxMessages, createdBefore)
+			if err != nil {
+				return report, fmt.Errorf("failed to trim room %s: %w", room.ID, err)
+			}
+
+			report.Rooms++
+			if removed > 0 {
+				report.TrimmedRooms++
+				report.Messages += removed
+				slog.Debug("room messages trimmed", "room_id", room.ID, "messages", removed)
+			}
+
+			expired, err := s.storage.DeleteExpiredInvites(ctx, room.ID, time.Now())
+			if err != nil {
+				return report, fmt.Errorf("failed to delete expired invites of room %s: %w", room.ID, err)
+			}
+
+			report.Invites += expired
+		}
+
+		if nextPageToken == "" {
+			return report, nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// retentionLimits returns the stricter of the server limits and the room overrides, zero means no limit.
+func (s *Store) retentionLimits(room *Room) (maxMessages int, maxRetention time.Duration) {
+	return stricterLimit(s.maxMessages, room.MaxMessages), stricterLimit(s.maxRetention, room.MaxRetention)
+}
+
+func stricterLimit[T int | time.Duration](a, b T) T {
+	switch {
+	case a <= 0:
+		return b
+	case b <= 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
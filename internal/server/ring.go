@@ -0,0 +1,134 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+// messageRing keeps the newest messages ordered by number. Once it is full, pushing a message
+// overwrites the oldest one, so memory stays constant however many messages a room receives.
+// A ring with a non-positive capacity is unbounded.
+type messageRing struct {
+	buf      []*Message
+	start    int
+	size     int
+	capacity int
+}
+
+func newMessageRing(capacity int) *messageRing {
+	r := &messageRing{
+		capacity: capacity,
+	}
+
+	if capacity > 0 {
+		r.buf = make([]*Message, capacity)
+	}
+
+	return r
+}
+
+func (r *messageRing) len() int {
+	return r.size
+}
+
+// at returns the i-th oldest message.
+func (r *messageRing) at(i int) *Message {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+func (r *messageRing) push(message *Message) {
+	if r.capacity <= 0 {
+		r.grow()
+	}
+
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = message
+		r.size++
+		return
+	}
+
+	r.buf[r.start] = message
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// expire drops messages created before createdBefore from the oldest side.
+func (r *messageRing) expire(createdBefore time.Time) {
+	for r.size > 0 && r.at(0).CreatedAt.Before(createdBefore) {
+		r.buf[r.start] = nil
+		r.start = (r.start + 1) % len(r.buf)
+		r.size--
+	}
+}
+
+// after returns a copy of the messages numbered after number.
+func (r *messageRing) after(number int) []*Message {
+	i := sort.Search(r.size, func(i int) bool {
+		return r.at(i).Number > number
+	})
+
+	messages := make([]*Message, 0, r.size-i)
+	for ; i < r.size; i++ {
+		messages = append(messages, r.at(i))
+	}
+
+	return messages
+}
+
+// get returns the message with the number or nil when there is none.
+func (r *messageRing) get(number int) *Message {
+	i, ok := r.index(number)
+	if !ok {
+		return nil
+	}
+
+	return r.at(i)
+}
+
+// replace swaps the message with the same number for message, it returns false when there is none.
+func (r *messageRing) replace(message *Message) bool {
+	i, ok := r.index(message.Number)
+	if !ok {
+		return false
+	}
+
+	r.buf[(r.start+i)%len(r.buf)] = message
+	return true
+}
+
+func (r *messageRing) index(number int) (int, bool) {
+	i := sort.Search(r.size, func(i int) bool {
+		return r.at(i).Number >= number
+	})
+
+	return i, i < r.size && r.at(i).Number == number
+}
+
+// resize changes the capacity of the ring, keeping the newest messages that fit.
+func (r *messageRing) resize(capacity int) {
+	kept := r.size
+	if capacity > 0 {
+		kept = min(kept, capacity)
+	}
+
+	buf := make([]*Message, max(capacity, kept))
+	for i := 0; i < kept; i++ {
+		buf[i] = r.at(r.size - kept + i)
+	}
+
+	r.buf, r.start, r.size, r.capacity = buf, 0, kept, capacity
+}
+
+// grow doubles the buffer of an unbounded ring when it is full.
+func (r *messageRing) grow() {
+	if r.size < len(r.buf) {
+		return
+	}
+
+	buf := make([]*Message, max(2*len(r.buf), 16))
+	for i := 0; i < r.size; i++ {
+		buf[i] = r.at(i)
+	}
+
+	r.buf = buf
+	r.start = 0
+}
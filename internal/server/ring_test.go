@@ -0,0 +1,57 @@
+package server
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func BenchmarkMessageRingPush(b *testing.B) {
+	ring := newMessageRing(1000)
+	message := &Message{Text: "hello", CreatedAt: time.Now()}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ring.push(message)
+	}
+}
+
+// BenchmarkRoomHubSustainedLoad appends b.N messages to a hub and reports the heap in use
+// afterward, it stays flat as b.N grows since the hub keeps at most maxMessages of them.
+func BenchmarkRoomHubSustainedLoad(b *testing.B) {
+	for _, maxMessages := range []int{100, 1000} {
+		b.Run(strconv.Itoa(maxMessages), func(b *testing.B) {
+			hub := &RoomHub{
+				store:        &Store{maxMessages: maxMessages, maxRetention: time.Hour},
+				room:         &Room{},
+				messages:     newMessageRing(maxMessages),
+				connections:  make(map[string]*Connection),
+				userSessions: make(map[string]int),
+			}
+
+			var before runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				hub.appendMessage(&Message{
+					Number:    i,
+					RoomID:    "room",
+					UserID:    "user",
+					Text:      "hello",
+					CreatedAt: time.Now(),
+				})
+			}
+			b.StopTimer()
+
+			var after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			b.ReportMetric(float64(hub.messages.len()), "retained_messages")
+			b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)), "retained_heap_bytes")
+		})
+	}
+}
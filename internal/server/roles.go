@@ -0,0 +1,117 @@
+package server
+
+// Role of a user in a room, roles are ordered from RoleReadOnly up to RoleOwner.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	RoleMember    Role = "member"
+	RoleReadOnly  Role = "read-only"
+)
+
+// Restriction limits a user in a room whether the user is a member or not. It outlives the membership,
+// so leaving the room or being removed from it does not lift it.
+type Restriction string
+
+const (
+	RestrictionNone Restriction = ""
+	// RestrictionReadOnly keeps the user read-only, also when joining again or acting in a public room.
+	RestrictionReadOnly Restriction = "read-only"
+	// RestrictionBanned keeps the user out of the room until a user allowed to manage members adds the user again.
+	RestrictionBanned Restriction = "banned"
+)
+
+// Permission is a set of actions a role allows within a room.
+type Permission uint
+
+const (
+	PermissionPost Permission = 1 << iota
+	// PermissionDeleteMessages allows deleting messages of other users.
+	PermissionDeleteMessages
+	// PermissionManageMembers allows adding users to the room and kicking members.
+	PermissionManageMembers
+	PermissionRename
+	// PermissionChangeSettings allows changing retention and privacy and archiving the room.
+	PermissionChangeSettings
+	PermissionManageRoles
+)
+
+var rolePermissions = map[Role]Permission{
+	RoleOwner: PermissionPost | PermissionDeleteMessages | PermissionManageMembers | PermissionRename |
+		PermissionChangeSettings | PermissionManageRoles,
+	RoleAdmin: PermissionPost | PermissionDeleteMessages | PermissionManageMembers | PermissionRename |
+		PermissionChangeSettings | PermissionManageRoles,
+	RoleModerator: PermissionPost | PermissionDeleteMessages | PermissionManageMembers,
+	RoleMember:    PermissionPost,
+	RoleReadOnly:  0,
+}
+
+var roleRanks = map[Role]int{
+	RoleReadOnly:  1,
+	RoleMember:    2,
+	RoleModerator: 3,
+	RoleAdmin:     4,
+	RoleOwner:     5,
+}
+
+// can reports whether the role has all of the permissions.
+func (r Role) can(permissions Permission) bool {
+	return rolePermissions[r]&permissions == permissions
+}
+
+// outranks reports whether the role is above other, users can only manage members ranked below them.
+func (r Role) outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+func (r Role) valid() bool {
+	return roleRanks[r] > 0
+}
+
+// roomRole returns the role of the user in the room, member is nil when the user is not a member.
+// The owner always has RoleOwner, members stored without a role have RoleMember and anyone can
+// act as RoleMember in a public room. Non-members of a private room have no role.
+func roomRole(room *Room, userID string, member *Member) Role {
+	switch {
+	case room.OwnerID == userID:
+		return RoleOwner
+	case member != nil:
+		return member.role()
+	case !room.Private:
+		return RoleMember
+	default:
+		return ""
+	}
+}
+
+// limit caps the role to the restriction, banned users have no role.
+func (r Restriction) limit(role Role) Role {
+	switch {
+	case r == RestrictionBanned:
+		return ""
+	case r == RestrictionReadOnly && role != "" && role != RoleOwner:
+		return RoleReadOnly
+	default:
+		return role
+	}
+}
+
+// restrictionOf returns the restriction stored along with the role, so it survives the membership.
+func restrictionOf(role Role) Restriction {
+	if role == RoleReadOnly {
+		return RestrictionReadOnly
+	}
+
+	return RestrictionNone
+}
+
+// role returns the stored role, members stored before roles were introduced have RoleMember.
+func (m *Member) role() Role {
+	if m.Role == "" {
+		return RoleMember
+	}
+
+	return m.Role
+}
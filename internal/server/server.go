@@ -6,38 +6,66 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"time"
 
 	"github.com/DavidMovas/chat-rooms/internal/errlog"
 
 	"github.com/DavidMovas/chat-rooms/apis/chat"
+	"github.com/DavidMovas/chat-rooms/internal/auth"
 	"github.com/DavidMovas/chat-rooms/internal/config"
 	"github.com/DavidMovas/chat-rooms/internal/log"
-	"github.com/redis/go-redis/v9"
+	"github.com/DavidMovas/chat-rooms/internal/metrics"
+	"github.com/DavidMovas/chat-rooms/internal/tlsconfig"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
 type Server struct {
 	cfg        *config.Config
-	rdb        *redis.Client
+	storage    Storage
+	store      *Store
 	listener   net.Listener
 	grpcServer *grpc.Server
 	closers    []func() error
 }
 
-func NewServer(cfg *config.Config, rdb *redis.Client) (*Server, error) {
-	grpcServer := grpc.NewServer(
-		grpc.ChainUnaryInterceptor(
-			log.UnaryServerInterceptor(),
-			errlog.UnaryServerInterceptor(),
-		),
-		grpc.ChainStreamInterceptor(
-			log.StreamServerInterceptor(),
-			errlog.StreamServerInterceptor(),
-		),
-	)
-
-	s := NewStorage(rdb, cfg)
+func NewServer(cfg *config.Config, storage Storage) (*Server, error) {
+	authenticator, err := auth.NewAuthenticator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authenticator: %w", err)
+	}
+
+	unary := []grpc.UnaryServerInterceptor{log.UnaryServerInterceptor()}
+	stream := []grpc.StreamServerInterceptor{log.StreamServerInterceptor()}
+	if authenticator != nil {
+		unary = append(unary, auth.UnaryServerInterceptor(authenticator))
+		stream = append(stream, auth.StreamServerInterceptor(authenticator))
+	} else {
+		slog.Warn("authentication is disabled, user ids of requests are trusted")
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append(unary, errlog.UnaryServerInterceptor())...),
+		grpc.ChainStreamInterceptor(append(stream, errlog.StreamServerInterceptor())...),
+	}
+
+	creds, err := serverCredentials(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tls config: %w", err)
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	grpcServer := grpc.NewServer(opts...)
+
+	s, err := NewStore(storage, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+
 	h := NewChatServer(s, cfg)
 	chat.RegisterChatServiceServer(grpcServer, h)
 	if cfg.Local {
@@ -47,7 +75,8 @@ func NewServer(cfg *config.Config, rdb *redis.Client) (*Server, error) {
 	return &Server{
 		cfg:        cfg,
 		grpcServer: grpcServer,
-		rdb:        rdb,
+		storage:    storage,
+		store:      s,
 	}, nil
 }
 
@@ -64,13 +93,65 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	s.closers = append(s.closers, s.listener.Close, s.rdb.Close)
+	s.closers = append(s.closers, s.listener.Close, s.storage.Close)
+
+	if s.cfg.MetricsPort > 0 {
+		s.startMetricsServer(logger)
+	}
+
+	if s.cfg.RetentionInterval > 0 {
+		s.startRetention()
+	}
+
+	if s.cfg.HubIdleTTL > 0 {
+		s.startHubEviction()
+	}
 
 	logger.Info("server started", "port", s.cfg.Port)
 	return s.grpcServer.Serve(s.listener)
 }
 
+func (s *Server) startMetricsServer(logger *slog.Logger) {
+	metricsServer := &http.Server{
+		Addr:              fmt.Sprintf(":%d", s.cfg.MetricsPort),
+		Handler:           metrics.Handler(),
+		ReadHeaderTimeout: time.Second * 5,
+	}
+
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("failed to serve metrics", "error", err)
+		}
+	}()
+
+	s.closers = append(s.closers, metricsServer.Close)
+	logger.Info("metrics server started", "port", s.cfg.MetricsPort)
+}
+
+func (s *Server) startRetention() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.store.RunRetention(ctx, s.cfg.RetentionInterval)
+
+	s.closers = append(s.closers, func() error {
+		cancel()
+		return nil
+	})
+}
+
+func (s *Server) startHubEviction() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.store.RunHubEviction(ctx, s.cfg.HubIdleTTL)
+
+	s.closers = append(s.closers, func() error {
+		cancel()
+		return nil
+	})
+}
+
 func (s *Server) Stop(ctx context.Context) error {
+	// Room connections are long-lived streams, they have to be closed for the graceful stop to finish.
+	storeErr := s.store.Close()
+
 	stopped := make(chan struct{})
 
 	go func() {
@@ -84,7 +165,7 @@ func (s *Server) Stop(ctx context.Context) error {
 	case <-stopped:
 	}
 
-	return withClosers(s.closers, nil)
+	return withClosers(s.closers, storeErr)
 }
 
 func (s *Server) Port() (int, error) {
@@ -95,6 +176,31 @@ func (s *Server) Port() (int, error) {
 	return s.listener.Addr().(*net.TCPAddr).Port, nil
 }
 
+// LoadedRoomHubs returns the number of room hubs currently loaded by the server.
+func (s *Server) LoadedRoomHubs() int {
+	return s.store.LoadedHubs()
+}
+
+// serverCredentials returns the TLS credentials of the listener, nil means plain TCP.
+func serverCredentials(cfg *config.Config) (credentials.TransportCredentials, error) {
+	switch {
+	case cfg.TLSCertFile == "" && cfg.TLSKeyFile == "":
+		if cfg.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("client ca file requires a server certificate")
+		}
+		return nil, nil
+	case cfg.TLSCertFile == "" || cfg.TLSKeyFile == "":
+		return nil, fmt.Errorf("both certificate and key files are required")
+	}
+
+	tlsCfg, err := tlsconfig.NewServerConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}
+
 func withClosers(closers []func() error, err error) error {
 	errs := []error{err}
 
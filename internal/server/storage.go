@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	_ encoding.BinaryUnmarshaler = (*RoomEvent)(nil)
+	_ encoding.BinaryMarshaler   = (*RoomEvent)(nil)
+)
+
+const (
+	RoomEventMessage     = "message"
+	RoomEventRoomUpdated = "room_updated"
+	RoomEventRoomDeleted = "room_deleted"
+	// RoomEventMemberRemoved is published when a member leaves or is kicked, hubs close the member connections.
+	RoomEventMemberRemoved = "member_removed"
+	// RoomEventMemberUpdated is published when the role of a member changes.
+	RoomEventMemberUpdated = "member_updated"
+	// RoomEventMessageEdited is published with the edited message, hubs replace the message they keep.
+	RoomEventMessageEdited = "message_edited"
+	// RoomEventMessageDeleted is published with the tombstone of the deleted message.
+	RoomEventMessageDeleted = "message_deleted"
+	// RoomEventReaction is published when a reaction is added to or removed from a message.
+	RoomEventReaction = "reaction"
+	// RoomEventPresence is published when users come online in the room or go offline.
+	RoomEventPresence = "presence"
+	// RoomEventReadReceipt is published when a user moves the read cursor forward.
+	RoomEventReadReceipt = "read_receipt"
+	// RoomEventTyping is published when a user starts or stops typing.
+	RoomEventTyping = "typing"
+)
+
+// Storage persists rooms and messages and delivers room events to every server instance sharing it.
+// Implementations return ErrRoomNotFound for unknown rooms.
+type Storage interface {
+	// CreateRoom stores the room with its owner as the first member.
+	CreateRoom(ctx context.Context, room *Room) error
+	// GetRooms returns rooms with their message statistics aligned with roomIDs, missing rooms are nil.
+	GetRooms(ctx context.Context, roomIDs []string) ([]*RoomInfo, error)
+	// ListRooms returns rooms ordered by the lowercased name and then by ID.
+	ListRooms(ctx context.Context, filter RoomFilter, pageSize int, pageToken string) (rooms []*RoomInfo, nextPageToken string, err error)
+	// UpdateRoom atomically applies update to the room and publishes RoomEventRoomUpdated.
+	UpdateRoom(ctx context.Context, roomID string, update func(room *Room) error) (*Room, error)
+	// DeleteRoom atomically removes the room with its messages, members, restrictions, invites and read cursors when check passes and publishes RoomEventRoomDeleted.
+	DeleteRoom(ctx context.Context, roomID string, check func(room *Room) error) error
+
+	// AddMember adds the user to the room, it returns false when the user is already a member.
+	AddMember(ctx context.Context, member *Member) (bool, error)
+	// RemoveMember removes the user from the room and publishes RoomEventMemberRemoved,
+	// it returns false when the user is not a member.
+	RemoveMember(ctx context.Context, roomID, userID string) (bool, error)
+	// UpdateMember atomically applies update to the member and publishes RoomEventMemberUpdated,
+	// it returns ErrNotMember when the user is not a member.
+	UpdateMember(ctx context.Context, roomID, userID string, update func(member *Member) error) (*Member, error)
+	// GetMember returns nil when the user is not a member of the room.
+	GetMember(ctx context.Context, roomID, userID string) (*Member, error)
+	// ListMembers returns room members ordered by user ID.
+	ListMembers(ctx context.Context, roomID string, pageSize int, pageToken string) (members []*Member, nextPageToken string, err error)
+	// SetRestriction stores the restriction of the user in the room, RestrictionNone removes it. It is kept when
+	// the user leaves or is removed. Banning publishes RoomEventMemberRemoved, so hubs close the connections
+	// of the user whether it is a member or not.
+	SetRestriction(ctx context.Context, roomID, userID string, restriction Restriction) error
+	// GetRestriction returns RestrictionNone when the user is not restricted in the room.
+	GetRestriction(ctx context.Context, roomID, userID string) (Restriction, error)
+
+	// CreateInvite stores the invite to an existing room.
+	CreateInvite(ctx context.Context, invite *Invite) error
+	// GetInvite returns nil when the invite does not exist.
+	GetInvite(ctx context.Context, code string) (*Invite, error)
+	// DeleteInvite removes the invite, it returns false when the invite does not exist.
+	DeleteInvite(ctx context.Context, code string) (bool, error)
+	// DeleteExpiredInvites removes the room invites that expired before expiredBefore, the audit of their uses is kept.
+	DeleteExpiredInvites(ctx context.Context, roomID string, expiredBefore time.Time) (removed int, err error)
+	// JoinWithInvite atomically adds the member returned by join, counts the invite use and records it
+	// in the room audit. A user who is already a member is returned with false without calling join.
+	// It returns ErrInviteNotFound for unknown invites.
+	JoinWithInvite(ctx context.Context, code, userID string, join func(invite *Invite) (*Member, error)) (member *Member, added bool, err error)
+	// ListInviteUses returns the uses of the room invites from the oldest one.
+	ListInviteUses(ctx context.Context, roomID string, pageSize int, pageToken string) (uses []*InviteUse, nextPageToken string, err error)
+
+	// SaveMessage allocates the next room message number, stores the message and publishes RoomEventMessage.
+	// Replies are counted in the thread of their root message, the event carries the new reply count.
+	SaveMessage(ctx context.Context, message *Message) error
+	// EditMessage stores the text of message as its new revision, keeping the previous text, and publishes
+	// RoomEventMessageEdited. It returns ErrMessageNotFound when the message is not stored and
+	// ErrMessageDeleted when it has been deleted.
+	EditMessage(ctx context.Context, message *Message) error
+	// DeleteMessage replaces the message with a tombstone carrying DeletedAt and DeletedBy of message,
+	// removes its revisions and reactions and publishes RoomEventMessageDeleted. It returns ErrMessageNotFound when
+	// the message is not stored and ErrMessageDeleted when it has already been deleted.
+	DeleteMessage(ctx context.Context, message *Message) error
+	// LoadMessageRevisions returns the previous texts of the message from the oldest one,
+	// it returns ErrMessageNotFound when the message is not stored.
+	LoadMessageRevisions(ctx context.Context, roomID string, number int) ([]*MessageRevision, error)
+	// AddReaction adds the reaction of the user to the message and publishes RoomEventReaction, it returns
+	// false when the user has already reacted with the emoji and ErrMessageDeleted for deleted messages.
+	AddReaction(ctx context.Context, roomID string, number int, emoji, userID string) (bool, error)
+	// RemoveReaction removes the reaction of the user from the message and publishes RoomEventReaction,
+	// it returns false when the user has not reacted with the emoji.
+	RemoveReaction(ctx context.Context, roomID string, number int, emoji, userID string) (bool, error)
+	// LoadThread returns up to limit replies to the root message numbered after the given number, from the oldest one.
+	LoadThread(ctx context.Context, roomID string, number, after, limit int) ([]*Message, error)
+	// LoadMessages returns up to limit newest messages, or all of them when limit is 0, and the last message number.
+	LoadMessages(ctx context.Context, roomID string, limit int) (messages []*Message, lastNumber int, err error)
+	// LoadMessageRange returns messages numbered from lo to hi inclusive.
+	LoadMessageRange(ctx context.Context, roomID string, lo, hi int) ([]*Message, error)
+	// MessageBounds returns the numbers of the first and the last stored messages. When the room has
+	// no messages, the first number is greater than the last one.
+	MessageBounds(ctx context.Context, roomID string) (firstNumber, lastNumber int, err error)
+
+	// TrimMessages removes all but the newest maxMessages messages and messages created before
+	// createdBefore, zero values disable the respective limit. The number of removed messages is returned.
+	TrimMessages(ctx context.Context, roomID string, maxMessages int, createdBefore time.Time) (removed int, err error)
+
+	// MarkRead moves the read cursor of the user forward to the receipt and publishes RoomEventReadReceipt,
+	// it returns false when the cursor is already at or after the receipt number.
+	MarkRead(ctx context.Context, roomID string, receipt *ReadReceipt) (bool, error)
+	// GetReadReceipt returns the read cursor of the user, nil when the user has never marked the room read.
+	GetReadReceipt(ctx context.Context, roomID, userID string) (*ReadReceipt, error)
+	// ListReadReceipts returns the read cursors of the room ordered by user ID.
+	ListReadReceipts(ctx context.Context, roomID string) ([]*ReadReceipt, error)
+
+	// SetPresence replaces the users connected to the room through the server instance, they stay online for ttl
+	// unless set again. Users whose presence has expired on any instance go offline, RoomEventPresence is published
+	// with the users who came online or went offline. A user stays online while connected through any instance.
+	SetPresence(ctx context.Context, roomID, instanceID string, userIDs []string, ttl time.Duration) error
+	// ListPresence returns IDs of users online in the room, ordered by ID.
+	ListPresence(ctx context.Context, roomID string) ([]string, error)
+	// PublishTyping publishes RoomEventTyping with the update, typing indicators are never stored.
+	PublishTyping(ctx context.Context, roomID string, update *TypingUpdate) error
+
+	// AcquireLease returns true when the named lease was free and is now held for ttl. All server
+	// instances sharing the storage share its leases, a lease can not be released before it expires.
+	AcquireLease(ctx context.Context, name string, ttl time.Duration) (bool, error)
+
+	// SubscribeRoomEvents returns a subscription that is active once the call returns,
+	// so no event published afterward is missed.
+	SubscribeRoomEvents(ctx context.Context, roomID string) (Subscription, error)
+
+	Close() error
+}
+
+// Subscription delivers room events in the order they were published.
+type Subscription interface {
+	// Events is closed once the subscription is closed.
+	Events() <-chan *RoomEvent
+	Close() error
+}
+
+// RoomEvent is published by the storage, so hubs of every server instance
+// apply the same changes in the same order.
+type RoomEvent struct {
+	Type    string   `json:"type"`
+	Message *Message `json:"message,omitempty"`
+	Room    *Room    `json:"room,omitempty"`
+	Member  *Member  `json:"member,omitempty"`
+	// ReplyCount is the reply count of the thread a RoomEventMessage reply was added to.
+	ReplyCount int             `json:"reply_count,omitempty"`
+	Reaction   *ReactionUpdate `json:"reaction,omitempty"`
+	// Presence is ordered by user ID.
+	Presence    []*PresenceUpdate `json:"presence,omitempty"`
+	ReadReceipt *ReadReceipt      `json:"read_receipt,omitempty"`
+	Typing      *TypingUpdate     `json:"typing,omitempty"`
+}
+
+func (e *RoomEvent) MarshalBinary() (data []byte, err error) {
+	return json.Marshal(e)
+}
+
+func (e *RoomEvent) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, e)
+}
+
+// tombstone returns the deleted message stripped of its text, deleted carries DeletedAt and DeletedBy.
+// The tombstone stays in its thread.
+func tombstone(message, deleted *Message) *Message {
+	return &Message{
+		Number:     message.Number,
+		RoomID:     message.RoomID,
+		UserID:     message.UserID,
+		CreatedAt:  message.CreatedAt,
+		ReplyTo:    message.ReplyTo,
+		ReplyCount: message.ReplyCount,
+		DeletedAt:  deleted.DeletedAt,
+		DeletedBy:  deleted.DeletedBy,
+	}
+}
+
+// roomOrderKey orders rooms by the lowercased name and then by the room ID,
+// storages use it to build room indexes and page tokens.
+func roomOrderKey(room *Room) string {
+	return strings.ToLower(room.Name) + "\x00" + room.ID
+}
+
+func encodePageToken(orderKey string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(orderKey))
+}
+
+func decodePageToken(pageToken string) (string, error) {
+	orderKey, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	return string(orderKey), nil
+}
+
+// encodePositionPageToken is used for lists without an order key, such as append only logs.
+func encodePositionPageToken(position int64) string {
+	return encodePageToken(strconv.FormatInt(position, 10))
+}
+
+func decodePositionPageToken(pageToken string) (int64, error) {
+	token, err := decodePageToken(pageToken)
+	if err != nil {
+		return 0, err
+	}
+
+	position, err := strconv.ParseInt(token, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	return position, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"sync"
+)
+
+const localSubscriptionBufferSize = 64
+
+// localEvents delivers room events to subscriptions of the same process. It is used by storages
+// that are not shared between server instances.
+type localEvents struct {
+	// mx is held from a change until its event is handed to all subscriptions,
+	// so events are delivered in the order the changes happened.
+	mx            sync.Mutex
+	subscriptions map[string]map[*localSubscription]struct{}
+}
+
+func newLocalEvents() *localEvents {
+	return &localEvents{
+		subscriptions: make(map[string]map[*localSubscription]struct{}),
+	}
+}
+
+// publishAfter applies change and publishes the returned event, no other change
+// of the same storage is applied until the event is delivered. A nil event is not published.
+func (e *localEvents) publishAfter(roomID string, change func() (*RoomEvent, error)) error {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	event, err := change()
+	if err != nil || event == nil {
+		return err
+	}
+
+	for sub := range e.subscriptions[roomID] {
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		}
+	}
+
+	return nil
+}
+
+func (e *localEvents) subscribe(roomID string) *localSubscription {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	sub := &localSubscription{
+		owner:  e,
+		roomID: roomID,
+		events: make(chan *RoomEvent, localSubscriptionBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	if e.subscriptions[roomID] == nil {
+		e.subscriptions[roomID] = make(map[*localSubscription]struct{})
+	}
+	e.subscriptions[roomID][sub] = struct{}{}
+
+	return sub
+}
+
+type localSubscription struct {
+	owner  *localEvents
+	roomID string
+	events chan *RoomEvent
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *localSubscription) Events() <-chan *RoomEvent {
+	return s.events
+}
+
+// Close unblocks a publisher waiting for the subscription first, so the events lock can be taken.
+func (s *localSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.owner.mx.Lock()
+		defer s.owner.mx.Unlock()
+
+		delete(s.owner.subscriptions[s.roomID], s)
+		if len(s.owner.subscriptions[s.roomID]) == 0 {
+			delete(s.owner.subscriptions, s.roomID)
+		}
+
+		close(s.events)
+	})
+
+	return nil
+}
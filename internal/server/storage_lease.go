@@ -0,0 +1,32 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// localLeases implements Storage.AcquireLease for storages used by a single server instance.
+type localLeases struct {
+	mx      sync.Mutex
+	expires map[string]time.Time
+}
+
+func newLocalLeases() *localLeases {
+	return &localLeases{
+		expires: make(map[string]time.Time),
+	}
+}
+
+func (l *localLeases) acquire(name string, ttl time.Duration) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	now := time.Now()
+	if now.Before(l.expires[name]) {
+		return false
+	}
+
+	l.expires[name] = now.Add(ttl)
+
+	return true
+}
@@ -0,0 +1,823 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Storage = (*MemoryStorage)(nil)
+
+// MemoryStorage keeps everything in process memory. It does not share events between
+// server instances and loses all data on restart, so it is meant for tests and local runs.
+type MemoryStorage struct {
+	mx          sync.RWMutex
+	rooms       map[string]*Room
+	messages    map[string][]*Message
+	revisions   map[string]map[int][]*MessageRevision
+	lastNumbers map[string]int
+	members     map[string]map[string]*Member
+	invites     map[string]*Invite
+	inviteUses  map[string][]*InviteUse
+	// readReceipts holds the read cursors of each room by user ID.
+	readReceipts map[string]map[string]*ReadReceipt
+	// restrictions holds the restrictions of each room by user ID, they outlive the memberships.
+	restrictions map[string]map[string]Restriction
+
+	events   *localEvents
+	leases   *localLeases
+	presence *localPresence
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		rooms:        make(map[string]*Room),
+		messages:     make(map[string][]*Message),
+		revisions:    make(map[string]map[int][]*MessageRevision),
+		lastNumbers:  make(map[string]int),
+		members:      make(map[string]map[string]*Member),
+		invites:      make(map[string]*Invite),
+		inviteUses:   make(map[string][]*InviteUse),
+		readReceipts: make(map[string]map[string]*ReadReceipt),
+		restrictions: make(map[string]map[string]Restriction),
+		events:       newLocalEvents(),
+		leases:       newLocalLeases(),
+		presence:     newLocalPresence(),
+	}
+}
+
+func (s *MemoryStorage) CreateRoom(_ context.Context, room *Room) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	stored := *room
+	s.rooms[room.ID] = &stored
+	s.members[room.ID] = map[string]*Member{
+		room.OwnerID: {RoomID: room.ID, UserID: room.OwnerID, JoinedAt: room.CreatedAt, Role: RoleOwner},
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) GetRooms(_ context.Context, roomIDs []string) ([]*RoomInfo, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	infos := make([]*RoomInfo, len(roomIDs))
+	for i, roomID := range roomIDs {
+		infos[i] = s.roomInfo(roomID)
+	}
+
+	return infos, nil
+}
+
+func (s *MemoryStorage) ListRooms(_ context.Context, filter RoomFilter, pageSize int, pageToken string) (rooms []*RoomInfo, nextPageToken string, err error) {
+	var after string
+	if pageToken != "" {
+		if after, err = decodePageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	prefix := strings.ToLower(filter.NamePrefix)
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	var keys []string
+	keyRooms := make(map[string]string)
+	for _, room := range s.rooms {
+		key := roomOrderKey(room)
+		if filter.OwnerID != "" && room.OwnerID != filter.OwnerID || !strings.HasPrefix(key, prefix) || key <= after ||
+			!s.visibleTo(room, filter.VisibleTo) {
+			continue
+		}
+
+		keys = append(keys, key)
+		keyRooms[key] = room.ID
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) > pageSize {
+		keys = keys[:pageSize]
+		nextPageToken = encodePageToken(keys[pageSize-1])
+	}
+
+	rooms = make([]*RoomInfo, len(keys))
+	for i, key := range keys {
+		rooms[i] = s.roomInfo(keyRooms[key])
+	}
+
+	return rooms, nextPageToken, nil
+}
+
+// visibleTo reports whether the room is listed to the user, it must be called with s.mx held.
+func (s *MemoryStorage) visibleTo(room *Room, userID string) bool {
+	return userID == "" || !room.Private || room.OwnerID == userID || s.members[room.ID][userID] != nil
+}
+
+func (s *MemoryStorage) UpdateRoom(_ context.Context, roomID string, update func(room *Room) error) (*Room, error) {
+	var room Room
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		stored := s.rooms[roomID]
+		if stored == nil {
+			return nil, ErrRoomNotFound
+		}
+
+		room = *stored
+		if err := update(&room); err != nil {
+			return nil, err
+		}
+
+		updated := room
+		s.rooms[roomID] = &updated
+
+		published := room
+		return &RoomEvent{Type: RoomEventRoomUpdated, Room: &published}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+func (s *MemoryStorage) DeleteRoom(_ context.Context, roomID string, check func(room *Room) error) error {
+	return s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		stored := s.rooms[roomID]
+		if stored == nil {
+			return nil, ErrRoomNotFound
+		}
+
+		room := *stored
+		if err := check(&room); err != nil {
+			return nil, err
+		}
+
+		delete(s.rooms, roomID)
+		delete(s.messages, roomID)
+		delete(s.revisions, roomID)
+		delete(s.lastNumbers, roomID)
+		delete(s.members, roomID)
+		delete(s.restrictions, roomID)
+		delete(s.inviteUses, roomID)
+		delete(s.readReceipts, roomID)
+		for code, invite := range s.invites {
+			if invite.RoomID == roomID {
+				delete(s.invites, code)
+			}
+		}
+
+		return &RoomEvent{Type: RoomEventRoomDeleted}, nil
+	})
+}
+
+func (s *MemoryStorage) AddMember(_ context.Context, member *Member) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.rooms[member.RoomID] == nil {
+		return false, ErrRoomNotFound
+	}
+
+	if s.members[member.RoomID][member.UserID] != nil {
+		return false, nil
+	}
+
+	stored := *member
+	s.members[member.RoomID][member.UserID] = &stored
+
+	return true, nil
+}
+
+func (s *MemoryStorage) RemoveMember(_ context.Context, roomID, userID string) (bool, error) {
+	var removed bool
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		member := s.members[roomID][userID]
+		if member == nil {
+			return nil, nil
+		}
+
+		delete(s.members[roomID], userID)
+		removed = true
+
+		published := *member
+		return &RoomEvent{Type: RoomEventMemberRemoved, Member: &published}, nil
+	})
+
+	return removed, err
+}
+
+func (s *MemoryStorage) UpdateMember(_ context.Context, roomID, userID string, update func(member *Member) error) (*Member, error) {
+	var member Member
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		stored := s.members[roomID][userID]
+		if stored == nil {
+			return nil, ErrNotMember
+		}
+
+		member = *stored
+		if err := update(&member); err != nil {
+			return nil, err
+		}
+
+		updated := member
+		s.members[roomID][userID] = &updated
+
+		published := member
+		return &RoomEvent{Type: RoomEventMemberUpdated, Member: &published}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func (s *MemoryStorage) SetRestriction(_ context.Context, roomID, userID string, restriction Restriction) error {
+	return s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		if s.rooms[roomID] == nil {
+			return nil, ErrRoomNotFound
+		}
+
+		if restriction == RestrictionNone {
+			delete(s.restrictions[roomID], userID)
+			return nil, nil
+		}
+
+		if s.restrictions[roomID] == nil {
+			s.restrictions[roomID] = make(map[string]Restriction)
+		}
+
+		s.restrictions[roomID][userID] = restriction
+		if restriction != RestrictionBanned {
+			return nil, nil
+		}
+
+		return &RoomEvent{Type: RoomEventMemberRemoved, Member: &Member{RoomID: roomID, UserID: userID}}, nil
+	})
+}
+
+func (s *MemoryStorage) GetRestriction(_ context.Context, roomID, userID string) (Restriction, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.restrictions[roomID][userID], nil
+}
+
+func (s *MemoryStorage) GetMember(_ context.Context, roomID, userID string) (*Member, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	stored := s.members[roomID][userID]
+	if stored == nil {
+		return nil, nil
+	}
+
+	member := *stored
+	return &member, nil
+}
+
+func (s *MemoryStorage) ListMembers(_ context.Context, roomID string, pageSize int, pageToken string) (members []*Member, nextPageToken string, err error) {
+	var after string
+	if pageToken != "" {
+		if after, err = decodePageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	var userIDs []string
+	for userID := range s.members[roomID] {
+		if userID > after {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	sort.Strings(userIDs)
+
+	if len(userIDs) > pageSize {
+		userIDs = userIDs[:pageSize]
+		nextPageToken = encodePageToken(userIDs[pageSize-1])
+	}
+
+	members = make([]*Member, len(userIDs))
+	for i, userID := range userIDs {
+		member := *s.members[roomID][userID]
+		members[i] = &member
+	}
+
+	return members, nextPageToken, nil
+}
+
+func (s *MemoryStorage) CreateInvite(_ context.Context, invite *Invite) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.rooms[invite.RoomID] == nil {
+		return ErrRoomNotFound
+	}
+
+	stored := *invite
+	s.invites[invite.Code] = &stored
+
+	return nil
+}
+
+func (s *MemoryStorage) GetInvite(_ context.Context, code string) (*Invite, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	stored := s.invites[code]
+	if stored == nil {
+		return nil, nil
+	}
+
+	invite := *stored
+	return &invite, nil
+}
+
+func (s *MemoryStorage) DeleteInvite(_ context.Context, code string) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.invites[code] == nil {
+		return false, nil
+	}
+
+	delete(s.invites, code)
+
+	return true, nil
+}
+
+func (s *MemoryStorage) DeleteExpiredInvites(_ context.Context, roomID string, expiredBefore time.Time) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var removed int
+	for code, invite := range s.invites {
+		if invite.RoomID == roomID && invite.ExpiresAt.Before(expiredBefore) {
+			delete(s.invites, code)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+func (s *MemoryStorage) JoinWithInvite(_ context.Context, code, userID string, join func(invite *Invite) (*Member, error)) (*Member, bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	stored := s.invites[code]
+	if stored == nil {
+		return nil, false, ErrInviteNotFound
+	}
+
+	if existing := s.members[stored.RoomID][userID]; existing != nil {
+		joined := *existing
+		return &joined, false, nil
+	}
+
+	invite := *stored
+	member, err := join(&invite)
+	if err != nil {
+		return nil, false, err
+	}
+
+	added := *member
+	s.members[member.RoomID][member.UserID] = &added
+
+	invite.Uses++
+	s.invites[code] = &invite
+
+	s.inviteUses[invite.RoomID] = append(s.inviteUses[invite.RoomID], &InviteUse{
+		Code:      code,
+		RoomID:    invite.RoomID,
+		UserID:    member.UserID,
+		CreatedBy: invite.CreatedBy,
+		UsedAt:    member.JoinedAt,
+	})
+
+	return member, true, nil
+}
+
+func (s *MemoryStorage) ListInviteUses(_ context.Context, roomID string, pageSize int, pageToken string) (uses []*InviteUse, nextPageToken string, err error) {
+	var offset int64
+	if pageToken != "" {
+		if offset, err = decodePositionPageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	stored := s.inviteUses[roomID]
+	if offset >= int64(len(stored)) {
+		return nil, "", nil
+	}
+
+	stored = stored[offset:]
+	if len(stored) > pageSize {
+		stored = stored[:pageSize]
+		nextPageToken = encodePositionPageToken(offset + int64(pageSize))
+	}
+
+	uses = make([]*InviteUse, len(stored))
+	for i, use := range stored {
+		copied := *use
+		uses[i] = &copied
+	}
+
+	return uses, nextPageToken, nil
+}
+
+func (s *MemoryStorage) SaveMessage(_ context.Context, message *Message) error {
+	return s.events.publishAfter(message.RoomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		var replyCount int
+		if message.ReplyTo != nil {
+			if i, ok := s.messageIndex(message.RoomID, *message.ReplyTo); ok {
+				root := *s.messages[message.RoomID][i]
+				root.ReplyCount++
+				s.messages[message.RoomID][i] = &root
+				replyCount = root.ReplyCount
+			}
+		}
+
+		message.Number = s.lastNumber(message.RoomID) + 1
+		stored := *message
+		s.messages[message.RoomID] = append(s.messages[message.RoomID], &stored)
+		s.lastNumbers[message.RoomID] = message.Number
+
+		published := *message
+		return &RoomEvent{Type: RoomEventMessage, Message: &published, ReplyCount: replyCount}, nil
+	})
+}
+
+func (s *MemoryStorage) EditMessage(_ context.Context, message *Message) error {
+	return s.events.publishAfter(message.RoomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		i, ok := s.messageIndex(message.RoomID, message.Number)
+		if !ok {
+			return nil, ErrMessageNotFound
+		}
+
+		previous := s.messages[message.RoomID][i]
+		if previous.Deleted() {
+			return nil, ErrMessageDeleted
+		}
+
+		revision := &MessageRevision{Text: previous.Text, CreatedAt: previous.CreatedAt}
+		if !previous.EditedAt.IsZero() {
+			revision.CreatedAt = previous.EditedAt
+		}
+
+		if s.revisions[message.RoomID] == nil {
+			s.revisions[message.RoomID] = make(map[int][]*MessageRevision)
+		}
+		s.revisions[message.RoomID][message.Number] = append(s.revisions[message.RoomID][message.Number], revision)
+
+		stored := *previous
+		stored.Text, stored.EditedAt = message.Text, message.EditedAt
+		s.messages[message.RoomID][i] = &stored
+
+		published := stored
+		return &RoomEvent{Type: RoomEventMessageEdited, Message: &published}, nil
+	})
+}
+
+func (s *MemoryStorage) DeleteMessage(_ context.Context, message *Message) error {
+	return s.events.publishAfter(message.RoomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		i, ok := s.messageIndex(message.RoomID, message.Number)
+		if !ok {
+			return nil, ErrMessageNotFound
+		}
+
+		previous := s.messages[message.RoomID][i]
+		if previous.Deleted() {
+			return nil, ErrMessageDeleted
+		}
+
+		delete(s.revisions[message.RoomID], message.Number)
+
+		stored := tombstone(previous, message)
+		s.messages[message.RoomID][i] = stored
+
+		published := *stored
+		return &RoomEvent{Type: RoomEventMessageDeleted, Message: &published}, nil
+	})
+}
+
+func (s *MemoryStorage) LoadMessageRevisions(_ context.Context, roomID string, number int) ([]*MessageRevision, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if _, ok := s.messageIndex(roomID, number); !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	stored := s.revisions[roomID][number]
+	revisions := make([]*MessageRevision, len(stored))
+	for i, r := range stored {
+		revision := *r
+		revisions[i] = &revision
+	}
+
+	return revisions, nil
+}
+
+func (s *MemoryStorage) AddReaction(_ context.Context, roomID string, number int, emoji, userID string) (bool, error) {
+	return s.updateReaction(roomID, number, emoji, userID, true)
+}
+
+func (s *MemoryStorage) RemoveReaction(_ context.Context, roomID string, number int, emoji, userID string) (bool, error) {
+	return s.updateReaction(roomID, number, emoji, userID, false)
+}
+
+func (s *MemoryStorage) updateReaction(roomID string, number int, emoji, userID string, added bool) (bool, error) {
+	var changed bool
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		i, ok := s.messageIndex(roomID, number)
+		if !ok {
+			return nil, ErrMessageNotFound
+		}
+
+		stored := s.messages[roomID][i]
+		if added && stored.Deleted() {
+			return nil, ErrMessageDeleted
+		}
+
+		reactions := withReaction(stored.Reactions, emoji, userID, added)
+		count := reactionCount(reactions, emoji)
+		if count == reactionCount(stored.Reactions, emoji) {
+			return nil, nil
+		}
+
+		updated := *stored
+		updated.Reactions = reactions
+		s.messages[roomID][i] = &updated
+		changed = true
+
+		return &RoomEvent{Type: RoomEventReaction, Reaction: &ReactionUpdate{
+			Number: number,
+			Emoji:  emoji,
+			UserID: userID,
+			Added:  added,
+			Count:  count,
+		}}, nil
+	})
+
+	return changed, err
+}
+
+func (s *MemoryStorage) MarkRead(_ context.Context, roomID string, receipt *ReadReceipt) (bool, error) {
+	var moved bool
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		if s.rooms[roomID] == nil {
+			return nil, ErrRoomNotFound
+		}
+
+		if current := s.readReceipts[roomID][receipt.UserID]; current != nil && current.Number >= receipt.Number {
+			return nil, nil
+		}
+
+		if s.readReceipts[roomID] == nil {
+			s.readReceipts[roomID] = make(map[string]*ReadReceipt)
+		}
+
+		stored := *receipt
+		s.readReceipts[roomID][receipt.UserID] = &stored
+		moved = true
+
+		return &RoomEvent{Type: RoomEventReadReceipt, ReadReceipt: &stored}, nil
+	})
+
+	return moved, err
+}
+
+func (s *MemoryStorage) GetReadReceipt(_ context.Context, roomID, userID string) (*ReadReceipt, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	receipt := s.readReceipts[roomID][userID]
+	if receipt == nil {
+		return nil, nil
+	}
+
+	stored := *receipt
+	return &stored, nil
+}
+
+func (s *MemoryStorage) ListReadReceipts(_ context.Context, roomID string) ([]*ReadReceipt, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	receipts := make([]*ReadReceipt, 0, len(s.readReceipts[roomID]))
+	for _, receipt := range s.readReceipts[roomID] {
+		stored := *receipt
+		receipts = append(receipts, &stored)
+	}
+
+	sort.Slice(receipts, func(i, j int) bool {
+		return receipts[i].UserID < receipts[j].UserID
+	})
+
+	return receipts, nil
+}
+
+func (s *MemoryStorage) LoadThread(_ context.Context, roomID string, number, after, limit int) ([]*Message, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	replies := make([]*Message, 0)
+	for _, m := range s.messages[roomID] {
+		if len(replies) == limit {
+			break
+		}
+
+		if m.Number > after && m.ReplyTo != nil && *m.ReplyTo == number {
+			reply := *m
+			replies = append(replies, &reply)
+		}
+	}
+
+	return replies, nil
+}
+
+func (s *MemoryStorage) LoadMessages(_ context.Context, roomID string, limit int) (messages []*Message, lastNumber int, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	stored := s.messages[roomID]
+	if limit > 0 && len(stored) > limit {
+		stored = stored[len(stored)-limit:]
+	}
+
+	return copyMessages(stored), s.lastNumber(roomID), nil
+}
+
+func (s *MemoryStorage) LoadMessageRange(_ context.Context, roomID string, lo, hi int) ([]*Message, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	stored := s.messages[roomID]
+	from := sort.Search(len(stored), func(i int) bool {
+		return stored[i].Number >= lo
+	})
+	to := sort.Search(len(stored), func(i int) bool {
+		return stored[i].Number > hi
+	})
+
+	if from >= to {
+		return nil, nil
+	}
+
+	return copyMessages(stored[from:to]), nil
+}
+
+func (s *MemoryStorage) MessageBounds(_ context.Context, roomID string) (firstNumber, lastNumber int, err error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	stored := s.messages[roomID]
+	if len(stored) == 0 {
+		return 0, -1, nil
+	}
+
+	return stored[0].Number, stored[len(stored)-1].Number, nil
+}
+
+func (s *MemoryStorage) TrimMessages(_ context.Context, roomID string, maxMessages int, createdBefore time.Time) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	stored := s.messages[roomID]
+
+	from := 0
+	if maxMessages > 0 && len(stored) > maxMessages {
+		from = len(stored) - maxMessages
+	}
+
+	for from < len(stored) && stored[from].CreatedAt.Before(createdBefore) {
+		from++
+	}
+
+	if from == 0 {
+		return 0, nil
+	}
+
+	for _, message := range stored[:from] {
+		delete(s.revisions[roomID], message.Number)
+	}
+
+	s.messages[roomID] = append([]*Message(nil), stored[from:]...)
+
+	return from, nil
+}
+
+func (s *MemoryStorage) SetPresence(_ context.Context, roomID, instanceID string, userIDs []string, ttl time.Duration) error {
+	return s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		return s.presence.set(roomID, instanceID, userIDs, ttl), nil
+	})
+}
+
+func (s *MemoryStorage) ListPresence(_ context.Context, roomID string) ([]string, error) {
+	return s.presence.list(roomID), nil
+}
+
+func (s *MemoryStorage) PublishTyping(_ context.Context, roomID string, update *TypingUpdate) error {
+	return s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		return &RoomEvent{Type: RoomEventTyping, Typing: update}, nil
+	})
+}
+
+func (s *MemoryStorage) AcquireLease(_ context.Context, name string, ttl time.Duration) (bool, error) {
+	return s.leases.acquire(name, ttl), nil
+}
+
+func (s *MemoryStorage) SubscribeRoomEvents(_ context.Context, roomID string) (Subscription, error) {
+	return s.events.subscribe(roomID), nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// roomInfo must be called with s.mx held.
+func (s *MemoryStorage) roomInfo(roomID string) *RoomInfo {
+	stored := s.rooms[roomID]
+	if stored == nil {
+		return nil
+	}
+
+	room := *stored
+	return &RoomInfo{
+		Room:              &room,
+		MessageCount:      int64(len(s.messages[roomID])),
+		LastMessageNumber: s.lastNumber(roomID),
+	}
+}
+
+// lastNumber must be called with s.mx held.
+func (s *MemoryStorage) lastNumber(roomID string) int {
+	if number, ok := s.lastNumbers[roomID]; ok {
+		return number
+	}
+
+	return -1
+}
+
+// messageIndex finds the message in the room messages, it must be called with s.mx held.
+func (s *MemoryStorage) messageIndex(roomID string, number int) (int, bool) {
+	stored := s.messages[roomID]
+	i := sort.Search(len(stored), func(i int) bool {
+		return stored[i].Number >= number
+	})
+
+	return i, i < len(stored) && stored[i].Number == number
+}
+
+func copyMessages(messages []*Message) []*Message {
+	copied := make([]*Message, len(messages))
+	for i, m := range messages {
+		message := *m
+		copied[i] = &message
+	}
+
+	return copied
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// localPresence implements Storage presence for storages that are not shared between processes,
+// it is kept in memory as the presence can not outlive the process anyway.
+type localPresence struct {
+	mx sync.Mutex
+	// expires holds the heartbeat expiration of users connected through each server instance by room.
+	expires map[string]map[presenceEntry]time.Time
+}
+
+type presenceEntry struct {
+	instanceID string
+	userID     string
+}
+
+func newLocalPresence() *localPresence {
+	return &localPresence{
+		expires: make(map[string]map[presenceEntry]time.Time),
+	}
+}
+
+// set replaces the users connected to the room through the instance and drops expired users of all instances.
+// It returns the event with the users who came online or went offline, nil when nothing changed.
+func (p *localPresence) set(roomID, instanceID string, userIDs []string, ttl time.Duration) *RoomEvent {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	now := time.Now()
+	before, after := make(map[string]bool), make(map[string]bool)
+
+	entries := p.expires[roomID]
+	for entry, expiresAt := range entries {
+		before[entry.userID] = true
+		if entry.instanceID == instanceID || !expiresAt.After(now) {
+			delete(entries, entry)
+		} else {
+			after[entry.userID] = true
+		}
+	}
+
+	if entries == nil {
+		entries = make(map[presenceEntry]time.Time, len(userIDs))
+	}
+
+	for _, userID := range userIDs {
+		entries[presenceEntry{instanceID: instanceID, userID: userID}] = now.Add(ttl)
+		after[userID] = true
+	}
+
+	if len(entries) > 0 {
+		p.expires[roomID] = entries
+	} else {
+		delete(p.expires, roomID)
+	}
+
+	var updates []*PresenceUpdate
+	for userID := range before {
+		if !after[userID] {
+			updates = append(updates, &PresenceUpdate{UserID: userID})
+		}
+	}
+	for userID := range after {
+		if !before[userID] {
+			updates = append(updates, &PresenceUpdate{UserID: userID, Online: true})
+		}
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].UserID < updates[j].UserID
+	})
+
+	return &RoomEvent{Type: RoomEventPresence, Presence: updates}
+}
+
+// list returns the users of the room connected through any instance whose heartbeat has not expired, ordered by ID.
+func (p *localPresence) list(roomID string) []string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	now := time.Now()
+	online := make(map[string]bool)
+	for entry, expiresAt := range p.expires[roomID] {
+		if expiresAt.After(now) {
+			online[entry.userID] = true
+		}
+	}
+
+	userIDs := make([]string, 0, len(online))
+	for userID := range online {
+		userIDs = append(userIDs, userID)
+	}
+
+	sort.Strings(userIDs)
+
+	return userIDs
+}
@@ -0,0 +1,1630 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ Storage = (*RedisStorage)(nil)
+
+const (
+	roomsNameIndexKey = "rooms:index:name"
+	// invitesIndexKey maps invite codes to their rooms, invites are kept in a hash next to the room.
+	invitesIndexKey = "invites:index"
+	// maxWatchAttempts bounds how many times an optimistic transaction is retried when a watched key changes.
+	maxWatchAttempts = 10
+)
+
+// RedisStorage keeps rooms as JSON values, messages in a per room log configured by
+// Config.RedisMessageLog and delivers room events through pub/sub channels. Room members are
+// kept as JSON values of a hash with a lexicographical index of their user IDs, invites as JSON
+// values of a hash with a list of their uses. The message log keeps the original messages, their
+// edits and tombstones are kept in a sorted set scored by the message number and applied when
+// messages are loaded. A tombstone replaces all edits of the message, so only the original text
+// is left in the log until the message is trimmed. Reply numbers are kept in a sorted set scored
+// by the number of their thread root, which gives the reply counts of loaded messages, and reactions
+// in a sorted set scored by the message number. Presence is kept in a sorted set of users prefixed with
+// the instance they are connected through, scored by the expiration of the instance heartbeat, and
+// read cursors as JSON values of a hash keyed by user ID.
+type RedisStorage struct {
+	rdb *redis.Client
+	log redisMessageLog
+
+	// instanceID identifies leases held by this storage.
+	instanceID string
+}
+
+func NewRedisStorage(rdb *redis.Client, cfg *config.Config) (*RedisStorage, error) {
+	log, err := newRedisMessageLog(cfg.RedisMessageLog, cfg.RedisStreamMaxLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisStorage{
+		rdb:        rdb,
+		log:        log,
+		instanceID: uuid.New().String(),
+	}, nil
+}
+
+func (s *RedisStorage) CreateRoom(ctx context.Context, room *Room) error {
+	bytes, err := json.Marshal(room)
+	if err != nil {
+		return fmt.Errorf("failed to marshal room: %w", err)
+	}
+
+	owner, err := json.Marshal(&Member{RoomID: room.ID, UserID: room.OwnerID, JoinedAt: room.CreatedAt, Role: RoleOwner})
+	if err != nil {
+		return fmt.Errorf("failed to marshal member: %w", err)
+	}
+
+	member := roomOrderKey(room)
+
+	tx := s.rdb.TxPipeline()
+	tx.Set(ctx, room.ID, string(bytes), 0)
+	tx.ZAdd(ctx, roomsNameIndexKey, redis.Z{Member: member})
+	tx.ZAdd(ctx, s.roomsOwnerIndexKey(room.OwnerID), redis.Z{Member: member})
+	tx.HSet(ctx, s.membersKey(room.ID), room.OwnerID, string(owner))
+	tx.ZAdd(ctx, s.membersIndexKey(room.ID), redis.Z{Member: room.OwnerID})
+
+	if _, err = tx.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to create room: %w", err)
+	}
+
+	return nil
+}
+
+// GetRooms fetches rooms with their message statistics in a single round trip.
+func (s *RedisStorage) GetRooms(ctx context.Context, roomIDs []string) ([]*RoomInfo, error) {
+	if len(roomIDs) == 0 {
+		return nil, nil
+	}
+
+	pipe := s.rdb.Pipeline()
+
+	getRoomCmds := make([]*redis.StringCmd, len(roomIDs))
+	countCmds := make([]*redis.IntCmd, len(roomIDs))
+	lastNumberCmds := make([]*redis.StringCmd, len(roomIDs))
+	for i, roomID := range roomIDs {
+		getRoomCmds[i] = pipe.Get(ctx, roomID)
+		countCmds[i] = s.log.count(ctx, pipe, roomID)
+		lastNumberCmds[i] = pipe.Get(ctx, messageNumberKey(roomID))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to load rooms: %w", err)
+	}
+
+	infos := make([]*RoomInfo, len(roomIDs))
+	for i := range roomIDs {
+		res, err := getRoomCmds[i].Result()
+		switch {
+		case errors.Is(err, redis.Nil):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed to load room: %w", err)
+		}
+
+		var room *Room
+		if err = json.Unmarshal([]byte(res), &room); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal room: %w", err)
+		}
+
+		lastNumber, err := lastNumberCmds[i].Int()
+		switch {
+		case errors.Is(err, redis.Nil):
+			lastNumber = -1
+		case err != nil:
+			return nil, fmt.Errorf("failed to load room: %w", err)
+		}
+
+		infos[i] = &RoomInfo{
+			Room:              room,
+			MessageCount:      countCmds[i].Val(),
+			LastMessageNumber: lastNumber,
+		}
+	}
+
+	return infos, nil
+}
+
+// ListRooms looks rooms up through lexicographical indexes, so filtering by owner
+// and name prefix never scans the whole keyspace.
+func (s *RedisStorage) ListRooms(ctx context.Context, filter RoomFilter, pageSize int, pageToken string) (rooms []*RoomInfo, nextPageToken string, err error) {
+	indexKey := roomsNameIndexKey
+	if filter.OwnerID != "" {
+		indexKey = s.roomsOwnerIndexKey(filter.OwnerID)
+	}
+
+	prefix := strings.ToLower(filter.NamePrefix)
+	rangeBy := &redis.ZRangeBy{
+		Min:   "[" + prefix,
+		Max:   "[" + prefix + "\xff",
+		Count: int64(pageSize + 1),
+	}
+
+	if pageToken != "" {
+		last, decodeErr := decodePageToken(pageToken)
+		if decodeErr != nil {
+			return nil, "", decodeErr
+		}
+		rangeBy.Min = "(" + last
+	}
+
+	// Rooms hidden from the user are skipped, so the index is read in batches until the page is full.
+	var keys []string
+	for {
+		members, err := s.rdb.ZRangeByLex(ctx, indexKey, rangeBy).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list rooms: %w", err)
+		}
+
+		infos, err := s.visibleRooms(ctx, members, filter.VisibleTo)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i, info := range infos {
+			if info != nil {
+				rooms = append(rooms, info)
+				keys = append(keys, members[i])
+			}
+		}
+
+		if len(rooms) > pageSize || int64(len(members)) < rangeBy.Count {
+			break
+		}
+
+		rangeBy.Min = "(" + members[len(members)-1]
+	}
+
+	if len(rooms) > pageSize {
+		rooms = rooms[:pageSize]
+		nextPageToken = encodePageToken(keys[pageSize-1])
+	}
+
+	return rooms, nextPageToken, nil
+}
+
+// visibleRooms loads the rooms of the name index members, rooms that do not exist or are hidden
+// from the user are nil, see RoomFilter.VisibleTo.
+func (s *RedisStorage) visibleRooms(ctx context.Context, members []string, userID string) ([]*RoomInfo, error) {
+	roomIDs := make([]string, len(members))
+	for i, member := range members {
+		_, roomIDs[i], _ = strings.Cut(member, "\x00")
+	}
+
+	infos, err := s.GetRooms(ctx, roomIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		return infos, nil
+	}
+
+	pipe := s.rdb.Pipeline()
+	memberCmds := make(map[int]*redis.BoolCmd)
+	for i, info := range infos {
+		if info != nil && info.Private && info.OwnerID != userID {
+			memberCmds[i] = pipe.HExists(ctx, s.membersKey(info.ID), userID)
+		}
+	}
+
+	if len(memberCmds) == 0 {
+		return infos, nil
+	}
+
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load members: %w", err)
+	}
+
+	for i, cmd := range memberCmds {
+		if !cmd.Val() {
+			infos[i] = nil
+		}
+	}
+
+	return infos, nil
+}
+
+// UpdateRoom applies update within an optimistic transaction and keeps the room indexes in sync.
+func (s *RedisStorage) UpdateRoom(ctx context.Context, roomID string, update func(room *Room) error) (*Room, error) {
+	var room *Room
+	err := watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		var err error
+		room, err = s.loadRoom(ctx, tx, roomID)
+		if err != nil {
+			return err
+		}
+
+		oldMember := roomOrderKey(room)
+		if err = update(room); err != nil {
+			return err
+		}
+		newMember := roomOrderKey(room)
+
+		bytes, err := json.Marshal(room)
+		if err != nil {
+			return fmt.Errorf("failed to marshal room: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, room.ID, string(bytes), 0)
+			if oldMember != newMember {
+				pipe.ZRem(ctx, roomsNameIndexKey, oldMember)
+				pipe.ZRem(ctx, s.roomsOwnerIndexKey(room.OwnerID), oldMember)
+				pipe.ZAdd(ctx, roomsNameIndexKey, redis.Z{Member: newMember})
+				pipe.ZAdd(ctx, s.roomsOwnerIndexKey(room.OwnerID), redis.Z{Member: newMember})
+			}
+			pipe.Publish(ctx, s.roomEventsChannel(room.ID), &RoomEvent{Type: RoomEventRoomUpdated, Room: room})
+			return nil
+		})
+		return err
+	}, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update room: %w", err)
+	}
+
+	return room, nil
+}
+
+func (s *RedisStorage) DeleteRoom(ctx context.Context, roomID string, check func(room *Room) error) error {
+	err := watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		room, err := s.loadRoom(ctx, tx, roomID)
+		if err != nil {
+			return err
+		}
+
+		if err = check(room); err != nil {
+			return err
+		}
+
+		codes, err := tx.HKeys(ctx, s.invitesKey(roomID)).Result()
+		if err != nil {
+			return err
+		}
+
+		member := roomOrderKey(room)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, room.ID, s.log.key(room.ID), messageNumberKey(room.ID), s.editsKey(room.ID), s.repliesKey(room.ID), s.reactionsKey(room.ID),
+				s.presenceKey(room.ID), s.readCursorsKey(room.ID), s.membersKey(room.ID), s.membersIndexKey(room.ID), s.restrictionsKey(room.ID),
+				s.invitesKey(room.ID), s.inviteUsesKey(room.ID))
+			if len(codes) > 0 {
+				pipe.HDel(ctx, invitesIndexKey, codes...)
+			}
+			pipe.ZRem(ctx, roomsNameIndexKey, member)
+			pipe.ZRem(ctx, s.roomsOwnerIndexKey(room.OwnerID), member)
+			pipe.Publish(ctx, s.roomEventsChannel(room.ID), &RoomEvent{Type: RoomEventRoomDeleted})
+			return nil
+		})
+		return err
+	}, roomID, s.invitesKey(roomID))
+	if err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+
+	return nil
+}
+
+// AddMember watches the room, so a member is never added to a room being deleted.
+func (s *RedisStorage) AddMember(ctx context.Context, member *Member) (bool, error) {
+	bytes, err := json.Marshal(member)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal member: %w", err)
+	}
+
+	var added *redis.BoolCmd
+	err = watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		if _, err := s.loadRoom(ctx, tx, member.RoomID); err != nil {
+			return err
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			added = pipe.HSetNX(ctx, s.membersKey(member.RoomID), member.UserID, string(bytes))
+			pipe.ZAdd(ctx, s.membersIndexKey(member.RoomID), redis.Z{Member: member.UserID})
+			return nil
+		})
+		return err
+	}, member.RoomID)
+	if err != nil {
+		return false, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	return added.Val(), nil
+}
+
+func (s *RedisStorage) RemoveMember(ctx context.Context, roomID, userID string) (bool, error) {
+	var removed bool
+	err := watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		member, err := s.loadMember(ctx, tx, roomID, userID)
+		if err != nil || member == nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, s.membersKey(roomID), userID)
+			pipe.ZRem(ctx, s.membersIndexKey(roomID), userID)
+			pipe.Publish(ctx, s.roomEventsChannel(roomID), &RoomEvent{Type: RoomEventMemberRemoved, Member: member})
+			return nil
+		})
+		removed = err == nil
+		return err
+	}, s.membersKey(roomID))
+	if err != nil {
+		return false, fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	return removed, nil
+}
+
+func (s *RedisStorage) UpdateMember(ctx context.Context, roomID, userID string, update func(member *Member) error) (*Member, error) {
+	var member *Member
+	err := watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		var err error
+		member, err = s.loadMember(ctx, tx, roomID, userID)
+		if err != nil {
+			return err
+		}
+
+		if member == nil {
+			return ErrNotMember
+		}
+
+		if err = update(member); err != nil {
+			return err
+		}
+
+		bytes, err := json.Marshal(member)
+		if err != nil {
+			return fmt.Errorf("failed to marshal member: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.membersKey(roomID), userID, string(bytes))
+			pipe.Publish(ctx, s.roomEventsChannel(roomID), &RoomEvent{Type: RoomEventMemberUpdated, Member: member})
+			return nil
+		})
+		return err
+	}, s.membersKey(roomID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update member: %w", err)
+	}
+
+	return member, nil
+}
+
+func (s *RedisStorage) SetRestriction(ctx context.Context, roomID, userID string, restriction Restriction) error {
+	err := watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		if _, err := s.loadRoom(ctx, tx, roomID); err != nil {
+			return err
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if restriction == RestrictionNone {
+				pipe.HDel(ctx, s.restrictionsKey(roomID), userID)
+				return nil
+			}
+
+			pipe.HSet(ctx, s.restrictionsKey(roomID), userID, string(restriction))
+			if restriction == RestrictionBanned {
+				pipe.Publish(ctx, s.roomEventsChannel(roomID), &RoomEvent{
+					Type:   RoomEventMemberRemoved,
+					Member: &Member{RoomID: roomID, UserID: userID},
+				})
+			}
+			return nil
+		})
+		return err
+	}, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to set restriction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStorage) GetRestriction(ctx context.Context, roomID, userID string) (Restriction, error) {
+	restriction, err := s.rdb.HGet(ctx, s.restrictionsKey(roomID), userID).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return RestrictionNone, nil
+	case err != nil:
+		return RestrictionNone, fmt.Errorf("failed to get restriction: %w", err)
+	}
+
+	return Restriction(restriction), nil
+}
+
+func (s *RedisStorage) GetMember(ctx context.Context, roomID, userID string) (*Member, error) {
+	member, err := s.loadMember(ctx, s.rdb, roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	return member, nil
+}
+
+func (s *RedisStorage) ListMembers(ctx context.Context, roomID string, pageSize int, pageToken string) (members []*Member, nextPageToken string, err error) {
+	rangeBy := &redis.ZRangeBy{
+		Min:   "-",
+		Max:   "+",
+		Count: int64(pageSize + 1),
+	}
+
+	if pageToken != "" {
+		last, decodeErr := decodePageToken(pageToken)
+		if decodeErr != nil {
+			return nil, "", decodeErr
+		}
+		rangeBy.Min = "(" + last
+	}
+
+	userIDs, err := s.rdb.ZRangeByLex(ctx, s.membersIndexKey(roomID), rangeBy).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list members: %w", err)
+	}
+
+	if len(userIDs) > pageSize {
+		userIDs = userIDs[:pageSize]
+		nextPageToken = encodePageToken(userIDs[pageSize-1])
+	}
+
+	if len(userIDs) == 0 {
+		return nil, nextPageToken, nil
+	}
+
+	values, err := s.rdb.HMGet(ctx, s.membersKey(roomID), userIDs...).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list members: %w", err)
+	}
+
+	members = make([]*Member, 0, len(values))
+	for _, value := range values {
+		res, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var member *Member
+		if err = json.Unmarshal([]byte(res), &member); err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	return members, nextPageToken, nil
+}
+
+// CreateInvite watches the room, so an invite is never added to a room being deleted.
+func (s *RedisStorage) CreateInvite(ctx context.Context, invite *Invite) error {
+	bytes, err := json.Marshal(invite)
+	if err != nil {
+		return fmt.Errorf("failed to marshal invite: %w", err)
+	}
+
+	err = watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		if _, err := s.loadRoom(ctx, tx, invite.RoomID); err != nil {
+			return err
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.invitesKey(invite.RoomID), invite.Code, string(bytes))
+			pipe.HSet(ctx, invitesIndexKey, invite.Code, invite.RoomID)
+			return nil
+		})
+		return err
+	}, invite.RoomID)
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStorage) GetInvite(ctx context.Context, code string) (*Invite, error) {
+	roomID, err := s.inviteRoomID(ctx, code)
+	if err != nil || roomID == "" {
+		return nil, err
+	}
+
+	invite, err := s.loadInvite(ctx, s.rdb, roomID, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+func (s *RedisStorage) DeleteInvite(ctx context.Context, code string) (bool, error) {
+	roomID, err := s.inviteRoomID(ctx, code)
+	if err != nil || roomID == "" {
+		return false, err
+	}
+
+	var deleted bool
+	err = watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		invite, err := s.loadInvite(ctx, tx, roomID, code)
+		if err != nil || invite == nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, s.invitesKey(roomID), code)
+			pipe.HDel(ctx, invitesIndexKey, code)
+			return nil
+		})
+		deleted = err == nil
+		return err
+	}, s.invitesKey(roomID))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete invite: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (s *RedisStorage) DeleteExpiredInvites(ctx context.Context, roomID string, expiredBefore time.Time) (int, error) {
+	var removed int
+	err := watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(ctx, s.invitesKey(roomID)).Result()
+		if err != nil {
+			return err
+		}
+
+		var codes []string
+		for code, value := range values {
+			var invite *Invite
+			if err = json.Unmarshal([]byte(value), &invite); err != nil {
+				return fmt.Errorf("failed to unmarshal invite: %w", err)
+			}
+
+			if invite.ExpiresAt.Before(expiredBefore) {
+				codes = append(codes, code)
+			}
+		}
+
+		if len(codes) == 0 {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HDel(ctx, s.invitesKey(roomID), codes...)
+			pipe.HDel(ctx, invitesIndexKey, codes...)
+			return nil
+		})
+		if err == nil {
+			removed = len(codes)
+		}
+		return err
+	}, s.invitesKey(roomID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired invites: %w", err)
+	}
+
+	return removed, nil
+}
+
+// JoinWithInvite watches the invites and the members of the room, so neither a revoked invite
+// nor a use beyond the limit can add a member.
+func (s *RedisStorage) JoinWithInvite(ctx context.Context, code, userID string, join func(invite *Invite) (*Member, error)) (*Member, bool, error) {
+	roomID, err := s.inviteRoomID(ctx, code)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if roomID == "" {
+		return nil, false, ErrInviteNotFound
+	}
+
+	var member *Member
+	var added bool
+	err = watch(ctx, s.rdb, func(tx *redis.Tx) error {
+		invite, err := s.loadInvite(ctx, tx, roomID, code)
+		if err != nil {
+			return err
+		}
+
+		if invite == nil {
+			return ErrInviteNotFound
+		}
+
+		if member, err = s.loadMember(ctx, tx, roomID, userID); err != nil || member != nil {
+			return err
+		}
+
+		if member, err = join(invite); err != nil {
+			return err
+		}
+
+		invite.Uses++
+		use := &InviteUse{
+			Code:      code,
+			RoomID:    roomID,
+			UserID:    member.UserID,
+			CreatedBy: invite.CreatedBy,
+			UsedAt:    member.JoinedAt,
+		}
+
+		memberBytes, err := json.Marshal(member)
+		if err != nil {
+			return fmt.Errorf("failed to marshal member: %w", err)
+		}
+
+		inviteBytes, err := json.Marshal(invite)
+		if err != nil {
+			return fmt.Errorf("failed to marshal invite: %w", err)
+		}
+
+		useBytes, err := json.Marshal(use)
+		if err != nil {
+			return fmt.Errorf("failed to marshal invite use: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.membersKey(roomID), member.UserID, string(memberBytes))
+			pipe.ZAdd(ctx, s.membersIndexKey(roomID), redis.Z{Member: member.UserID})
+			pipe.HSet(ctx, s.invitesKey(roomID), code, string(inviteBytes))
+			pipe.RPush(ctx, s.inviteUsesKey(roomID), string(useBytes))
+			return nil
+		})
+		added = err == nil
+		return err
+	}, s.invitesKey(roomID), s.membersKey(roomID))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to join with invite: %w", err)
+	}
+
+	return member, added, nil
+}
+
+func (s *RedisStorage) ListInviteUses(ctx context.Context, roomID string, pageSize int, pageToken string) (uses []*InviteUse, nextPageToken string, err error) {
+	var offset int64
+	if pageToken != "" {
+		if offset, err = decodePositionPageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	values, err := s.rdb.LRange(ctx, s.inviteUsesKey(roomID), offset, offset+int64(pageSize)).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list invite uses: %w", err)
+	}
+
+	if len(values) > pageSize {
+		values = values[:pageSize]
+		nextPageToken = encodePositionPageToken(offset + int64(pageSize))
+	}
+
+	uses = make([]*InviteUse, len(values))
+	for i, value := range values {
+		if err = json.Unmarshal([]byte(value), &uses[i]); err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal invite use: %w", err)
+		}
+	}
+
+	return uses, nextPageToken, nil
+}
+
+// SaveMessage allocates the number, stores and publishes the message in a single atomic step.
+func (s *RedisStorage) SaveMessage(ctx context.Context, message *Message) error {
+	number, err := s.log.save(ctx, s.rdb, message, messageNumberKey(message.RoomID), s.repliesKey(message.RoomID), s.roomEventsChannel(message.RoomID))
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+
+	message.Number = number
+
+	return nil
+}
+
+// EditMessage records the edit with a revision number allocated atomically, so the last
+// published edit of a message is always the one applied when it is loaded.
+func (s *RedisStorage) EditMessage(ctx context.Context, message *Message) error {
+	stored, err := s.loadMessage(ctx, message.RoomID, message.Number)
+	if err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	if err = s.applyReplyCounts(ctx, message.RoomID, []*Message{stored}); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	if err = s.applyReactions(ctx, message.RoomID, []*Message{stored}); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	stored.Text, stored.EditedAt = message.Text, message.EditedAt
+
+	keys := []string{s.editsKey(message.RoomID)}
+	revision, err := editMessageScript.Run(ctx, s.rdb, keys, stored, s.roomEventsChannel(message.RoomID)).Int()
+	if err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	if revision < 0 {
+		return fmt.Errorf("failed to edit message: %w", ErrMessageDeleted)
+	}
+
+	return nil
+}
+
+// DeleteMessage replaces the edits of the message with its tombstone.
+func (s *RedisStorage) DeleteMessage(ctx context.Context, message *Message) error {
+	stored, err := s.loadMessage(ctx, message.RoomID, message.Number)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	if err = s.applyReplyCounts(ctx, message.RoomID, []*Message{stored}); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	keys := []string{s.editsKey(message.RoomID), s.reactionsKey(message.RoomID)}
+	deleted, err := deleteMessageScript.Run(ctx, s.rdb, keys, tombstone(stored, message), s.roomEventsChannel(message.RoomID)).Bool()
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	if !deleted {
+		return fmt.Errorf("failed to delete message: %w", ErrMessageDeleted)
+	}
+
+	return nil
+}
+
+// LoadMessageRevisions returns the original text from the message log followed by all edits but the last one.
+func (s *RedisStorage) LoadMessageRevisions(ctx context.Context, roomID string, number int) ([]*MessageRevision, error) {
+	message, err := s.loadMessage(ctx, roomID, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message revisions: %w", err)
+	}
+
+	edits, err := s.loadEdits(ctx, roomID, number, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message revisions: %w", err)
+	}
+
+	revisions := make([]*MessageRevision, 0, len(edits))
+	if len(edits) > 0 && edits[len(edits)-1].DeletedAt.IsZero() {
+		revisions = append(revisions, &MessageRevision{Text: message.Text, CreatedAt: message.CreatedAt})
+	}
+
+	for i := 0; i < len(edits)-1; i++ {
+		revisions = append(revisions, &MessageRevision{Text: edits[i].Text, CreatedAt: edits[i].EditedAt})
+	}
+
+	return revisions, nil
+}
+
+func (s *RedisStorage) AddReaction(ctx context.Context, roomID string, number int, emoji, userID string) (bool, error) {
+	added, err := s.updateReaction(ctx, roomID, number, emoji, userID, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+
+	return added, nil
+}
+
+func (s *RedisStorage) RemoveReaction(ctx context.Context, roomID string, number int, emoji, userID string) (bool, error) {
+	removed, err := s.updateReaction(ctx, roomID, number, emoji, userID, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+
+	return removed, nil
+}
+
+func (s *RedisStorage) updateReaction(ctx context.Context, roomID string, number int, emoji, userID string, added bool) (bool, error) {
+	member, err := json.Marshal(&reactionMember{Number: number, Emoji: emoji, UserID: userID})
+	if err != nil {
+		return false, err
+	}
+
+	add := 0
+	if added {
+		add = 1
+	}
+
+	keys := []string{s.reactionsKey(roomID), s.editsKey(roomID)}
+	changed, err := updateReactionScript.Run(ctx, s.rdb, keys, member, add, s.roomEventsChannel(roomID)).Int()
+	switch {
+	case err != nil:
+		return false, err
+	case changed < 0:
+		return false, ErrMessageDeleted
+	}
+
+	return changed > 0, nil
+}
+
+// LoadThread looks the reply numbers up in the room replies and loads only those messages from the log.
+func (s *RedisStorage) LoadThread(ctx context.Context, roomID string, number, after, limit int) ([]*Message, error) {
+	members, err := s.rdb.ZRangeByScore(ctx, s.repliesKey(roomID), &redis.ZRangeBy{
+		Min: strconv.Itoa(number),
+		Max: strconv.Itoa(number),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	numbers := make([]int, 0, len(members))
+	for _, member := range members {
+		n, err := strconv.Atoi(member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load thread: invalid reply number %q: %w", member, err)
+		}
+
+		if n > after {
+			numbers = append(numbers, n)
+		}
+	}
+
+	// Replies of the same thread share the score and are ordered as strings.
+	sort.Ints(numbers)
+	numbers = numbers[:min(len(numbers), limit)]
+	if len(numbers) == 0 {
+		return []*Message{}, nil
+	}
+
+	// Replies are scattered over the log, so they are loaded by number rather than by range.
+	pipe := s.rdb.Pipeline()
+	getReplies := s.log.numbered(ctx, pipe, roomID, numbers)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	replies, err := getReplies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	if err = s.applyChanges(ctx, roomID, replies); err != nil {
+		return nil, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	return replies, nil
+}
+
+func (s *RedisStorage) LoadMessages(ctx context.Context, roomID string, limit int) (messages []*Message, lastNumber int, err error) {
+	tx := s.rdb.TxPipeline()
+
+	getMessages := s.log.latest(ctx, tx, roomID, limit)
+	getMessagesNumberCmd := tx.Get(ctx, messageNumberKey(roomID))
+
+	if _, err = tx.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	messages, err = getMessages()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	lastNumber, err = getMessagesNumberCmd.Int()
+	switch {
+	case errors.Is(err, redis.Nil):
+		lastNumber = -1
+	case err != nil:
+		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	if err = s.applyChanges(ctx, roomID, messages); err != nil {
+		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return messages, lastNumber, nil
+}
+
+func (s *RedisStorage) LoadMessageRange(ctx context.Context, roomID string, lo, hi int) ([]*Message, error) {
+	messages, err := s.log.messageRange(ctx, s.rdb, roomID, lo, hi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	if err = s.applyChanges(ctx, roomID, messages); err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (s *RedisStorage) MessageBounds(ctx context.Context, roomID string) (firstNumber, lastNumber int, err error) {
+	firstNumber, lastNumber, err = s.log.bounds(ctx, s.rdb, roomID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load message bounds: %w", err)
+	}
+
+	return firstNumber, lastNumber, nil
+}
+
+// TrimMessages removes edits, threads and reactions of the trimmed messages once the log is trimmed.
+func (s *RedisStorage) TrimMessages(ctx context.Context, roomID string, maxMessages int, createdBefore time.Time) (int, error) {
+	removed, err := s.log.trim(ctx, s.rdb, roomID, maxMessages, createdBefore)
+	if err != nil {
+		return removed, fmt.Errorf("failed to trim messages: %w", err)
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	firstNumber, lastNumber, err := s.log.bounds(ctx, s.rdb, roomID)
+	if err != nil {
+		return removed, fmt.Errorf("failed to trim message edits: %w", err)
+	}
+
+	maxScore := "+inf"
+	if firstNumber <= lastNumber {
+		maxScore = "(" + strconv.Itoa(firstNumber)
+	}
+
+	pipe := s.rdb.Pipeline()
+	pipe.ZRemRangeByScore(ctx, s.editsKey(roomID), "-inf", maxScore)
+	pipe.ZRemRangeByScore(ctx, s.repliesKey(roomID), "-inf", maxScore)
+	pipe.ZRemRangeByScore(ctx, s.reactionsKey(roomID), "-inf", maxScore)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return removed, fmt.Errorf("failed to trim message edits: %w", err)
+	}
+
+	return removed, nil
+}
+
+func (s *RedisStorage) MarkRead(ctx context.Context, roomID string, receipt *ReadReceipt) (bool, error) {
+	value, err := json.Marshal(receipt)
+	if err != nil {
+		return false, err
+	}
+
+	keys := []string{s.readCursorsKey(roomID), roomID}
+	moved, err := markReadScript.Run(ctx, s.rdb, keys, value, s.roomEventsChannel(roomID)).Int()
+	switch {
+	case err != nil:
+		return false, fmt.Errorf("failed to mark read: %w", err)
+	case moved < 0:
+		return false, ErrRoomNotFound
+	}
+
+	return moved > 0, nil
+}
+
+func (s *RedisStorage) GetReadReceipt(ctx context.Context, roomID, userID string) (*ReadReceipt, error) {
+	res, err := s.rdb.HGet(ctx, s.readCursorsKey(roomID), userID).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get read receipt: %w", err)
+	}
+
+	var receipt *ReadReceipt
+	if err = json.Unmarshal([]byte(res), &receipt); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal read receipt: %w", err)
+	}
+
+	return receipt, nil
+}
+
+func (s *RedisStorage) ListReadReceipts(ctx context.Context, roomID string) ([]*ReadReceipt, error) {
+	values, err := s.rdb.HVals(ctx, s.readCursorsKey(roomID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list read receipts: %w", err)
+	}
+
+	receipts := make([]*ReadReceipt, len(values))
+	for i, value := range values {
+		if err = json.Unmarshal([]byte(value), &receipts[i]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal read receipt: %w", err)
+		}
+	}
+
+	sort.Slice(receipts, func(i, j int) bool {
+		return receipts[i].UserID < receipts[j].UserID
+	})
+
+	return receipts, nil
+}
+
+func (s *RedisStorage) SetPresence(ctx context.Context, roomID, instanceID string, userIDs []string, ttl time.Duration) error {
+	now := time.Now()
+	args := make([]any, 0, len(userIDs)+4)
+	args = append(args, instanceID, now.UnixMilli(), now.Add(ttl).UnixMilli(), s.roomEventsChannel(roomID))
+	for _, userID := range userIDs {
+		args = append(args, userID)
+	}
+
+	if err := setPresenceScript.Run(ctx, s.rdb, []string{s.presenceKey(roomID)}, args...).Err(); err != nil {
+		return fmt.Errorf("failed to set presence: %w", err)
+	}
+
+	return nil
+}
+
+// ListPresence skips the users of instances that have stopped sending heartbeats, they are removed
+// by the next SetPresence of the room.
+func (s *RedisStorage) ListPresence(ctx context.Context, roomID string) ([]string, error) {
+	members, err := s.rdb.ZRangeByScore(ctx, s.presenceKey(roomID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list presence: %w", err)
+	}
+
+	userIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		_, userID, _ := strings.Cut(member, ":")
+		userIDs = append(userIDs, userID)
+	}
+
+	sort.Strings(userIDs)
+
+	return slices.Compact(userIDs), nil
+}
+
+func (s *RedisStorage) PublishTyping(ctx context.Context, roomID string, update *TypingUpdate) error {
+	event := &RoomEvent{Type: RoomEventTyping, Typing: update}
+	if err := s.rdb.Publish(ctx, s.roomEventsChannel(roomID), event).Err(); err != nil {
+		return fmt.Errorf("failed to publish typing: %w", err)
+	}
+
+	return nil
+}
+
+// AcquireLease is shared by all server instances using the same redis.
+func (s *RedisStorage) AcquireLease(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	acquired, err := s.rdb.SetNX(ctx, s.leaseKey(name), s.instanceID, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+
+	return acquired, nil
+}
+
+func (s *RedisStorage) SubscribeRoomEvents(ctx context.Context, roomID string) (Subscription, error) {
+	pubsub := s.rdb.Subscribe(ctx, s.roomEventsChannel(roomID))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to room events: %w", err)
+	}
+
+	return newRedisSubscription(pubsub), nil
+}
+
+func (s *RedisStorage) Close() error {
+	return s.rdb.Close()
+}
+
+// watch runs fn in an optimistic transaction over keys and retries it while a watched key is
+// modified before the transaction executes, up to maxWatchAttempts times.
+func watch(ctx context.Context, rdb *redis.Client, fn func(tx *redis.Tx) error, keys ...string) error {
+	var err error
+	for i := 0; i < maxWatchAttempts; i++ {
+		err = rdb.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *RedisStorage) loadRoom(ctx context.Context, rdb redis.Cmdable, roomID string) (*Room, error) {
+	res, err := rdb.Get(ctx, roomID).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, ErrRoomNotFound
+	case err != nil:
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	var room *Room
+	if err = json.Unmarshal([]byte(res), &room); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal room: %w", err)
+	}
+
+	return room, nil
+}
+
+func (s *RedisStorage) loadMember(ctx context.Context, rdb redis.Cmdable, roomID, userID string) (*Member, error) {
+	res, err := rdb.HGet(ctx, s.membersKey(roomID), userID).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	var member *Member
+	if err = json.Unmarshal([]byte(res), &member); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal member: %w", err)
+	}
+
+	return member, nil
+}
+
+// editMessageScript allocates the next revision number of the message, stores the edit scored
+// by the message number and publishes the edited message to the room events channel. The revision
+// is returned, -1 when the message has been deleted.
+//
+// KEYS[1] - room message edits sorted set.
+// ARGV[1] - edited message, ARGV[2] - room events channel.
+var editMessageScript = redis.NewScript(`
+local message = cjson.decode(ARGV[1])
+local number = message['Number']
+for _, value in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], number, number)) do
+	if cjson.decode(value)['DeletedAt'] then
+		return -1
+	end
+end
+
+local revision = redis.call('ZCOUNT', KEYS[1], number, number) + 1
+
+redis.call('ZADD', KEYS[1], number, cjson.encode({
+	Number = number, Revision = revision, Text = message['Text'], EditedAt = message['EditedAt'],
+}))
+redis.call('PUBLISH', ARGV[2], cjson.encode({type = 'message_edited', message = message}))
+
+return revision
+`)
+
+// deleteMessageScript replaces the edits of the message with its tombstone, removes its reactions and publishes the tombstone
+// to the room events channel. It returns 0 when the message has already been deleted.
+//
+// KEYS[1] - room message edits sorted set, KEYS[2] - room reactions sorted set.
+// ARGV[1] - message tombstone, ARGV[2] - room events channel.
+var deleteMessageScript = redis.NewScript(`
+local message = cjson.decode(ARGV[1])
+local number = message['Number']
+for _, value in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], number, number)) do
+	if cjson.decode(value)['DeletedAt'] then
+		return 0
+	end
+end
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], number, number)
+redis.call('ZREMRANGEBYSCORE', KEYS[2], number, number)
+redis.call('ZADD', KEYS[1], number, cjson.encode({
+	Number = number, Revision = 1, DeletedAt = message['DeletedAt'], DeletedBy = message['DeletedBy'],
+}))
+redis.call('PUBLISH', ARGV[2], cjson.encode({type = 'message_deleted', message = message}))
+
+return 1
+`)
+
+// updateReactionScript adds or removes the reaction and publishes the change with the new number of users
+// who reacted with the emoji. It returns 0 when nothing changed and -1 when the message has been deleted.
+//
+// KEYS[1] - room reactions sorted set, KEYS[2] - room message edits sorted set.
+// ARGV[1] - reaction member, ARGV[2] - 1 to add the reaction or 0 to remove it, ARGV[3] - room events channel.
+var updateReactionScript = redis.NewScript(`
+local reaction = cjson.decode(ARGV[1])
+local number = reaction['Number']
+local added = ARGV[2] == '1'
+if added then
+	for _, value in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], number, number)) do
+		if cjson.decode(value)['DeletedAt'] then
+			return -1
+		end
+	end
+
+	if redis.call('ZADD', KEYS[1], number, ARGV[1]) == 0 then
+		return 0
+	end
+elseif redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+local count = 0
+for _, value in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], number, number)) do
+	if cjson.decode(value)['Emoji'] == reaction['Emoji'] then
+		count = count + 1
+	end
+end
+
+redis.call('PUBLISH', ARGV[3], cjson.encode({type = 'reaction', reaction = {
+	Number = number, Emoji = reaction['Emoji'], UserID = reaction['UserID'], Added = added, Count = count,
+}}))
+
+return 1
+`)
+
+// markReadScript stores the read receipt unless the cursor of the user is already at or after it and publishes
+// the receipt to the room events channel. It returns 1 when the cursor moved and -1 when the room does not exist.
+//
+// KEYS[1] - room read cursors hash, KEYS[2] - room.
+// ARGV[1] - read receipt, ARGV[2] - room events channel.
+var markReadScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return -1
+end
+
+local receipt = cjson.decode(ARGV[1])
+local current = redis.call('HGET', KEYS[1], receipt['UserID'])
+if current and cjson.decode(current)['Number'] >= receipt['Number'] then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], receipt['UserID'], ARGV[1])
+redis.call('PUBLISH', ARGV[2], cjson.encode({type = 'read_receipt', read_receipt = receipt}))
+
+return 1
+`)
+
+// setPresenceScript replaces the users connected through the instance and drops users of all instances
+// whose heartbeat has expired. The change is published to the room events channel when users came online
+// or went offline, the key expires with the last heartbeat. The number of changed users is returned.
+//
+// KEYS[1] - room presence sorted set.
+// ARGV[1] - instance ID, ARGV[2] - current time and ARGV[3] - heartbeat expiration in unix milliseconds,
+// ARGV[4] - room events channel, ARGV[5...] - IDs of users connected through the instance.
+var setPresenceScript = redis.NewScript(`
+local prefix = ARGV[1] .. ':'
+local now = tonumber(ARGV[2])
+local before, after = {}, {}
+local entries = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+for i = 1, #entries, 2 do
+	local userID = string.match(entries[i], '^[^:]*:(.*)$')
+	before[userID] = true
+	if string.sub(entries[i], 1, #prefix) == prefix or tonumber(entries[i + 1]) <= now then
+		redis.call('ZREM', KEYS[1], entries[i])
+	else
+		after[userID] = true
+	end
+end
+
+for i = 5, #ARGV do
+	redis.call('ZADD', KEYS[1], ARGV[3], prefix .. ARGV[i])
+	after[ARGV[i]] = true
+end
+
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #last > 0 then
+	redis.call('PEXPIREAT', KEYS[1], last[2])
+end
+
+local updates = {}
+for userID in pairs(before) do
+	if not after[userID] then
+		table.insert(updates, {UserID = userID, Online = false})
+	end
+end
+for userID in pairs(after) do
+	if not before[userID] then
+		table.insert(updates, {UserID = userID, Online = true})
+	end
+end
+
+if #updates > 0 then
+	table.sort(updates, function(a, b) return a.UserID < b.UserID end)
+	redis.call('PUBLISH', ARGV[4], cjson.encode({type = 'presence', presence = updates}))
+end
+
+return #updates
+`)
+
+// reactionMember is a reaction of a user stored by updateReactionScript.
+type reactionMember struct {
+	Number int
+	Emoji  string
+	UserID string
+}
+
+// messageEdit is a text of a message stored by editMessageScript or a tombstone stored by deleteMessageScript.
+type messageEdit struct {
+	Number    int
+	Revision  int
+	Text      string
+	EditedAt  time.Time
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+// loadMessage returns ErrMessageNotFound when the message is not in the log.
+func (s *RedisStorage) loadMessage(ctx context.Context, roomID string, number int) (*Message, error) {
+	messages, err := s.log.messageRange(ctx, s.rdb, roomID, number, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return nil, ErrMessageNotFound
+	}
+
+	return messages[0], nil
+}
+
+// loadEdits returns edits of messages numbered from lo to hi ordered by number and revision.
+func (s *RedisStorage) loadEdits(ctx context.Context, roomID string, lo, hi int) ([]*messageEdit, error) {
+	values, err := s.rdb.ZRangeByScore(ctx, s.editsKey(roomID), &redis.ZRangeBy{
+		Min: strconv.Itoa(lo),
+		Max: strconv.Itoa(hi),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	edits := make([]*messageEdit, len(values))
+	for i, value := range values {
+		if err = json.Unmarshal([]byte(value), &edits[i]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message edit: %w", err)
+		}
+	}
+
+	// Edits of the same message share the score and are ordered by their JSON, not by revision.
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].Number != edits[j].Number {
+			return edits[i].Number < edits[j].Number
+		}
+		return edits[i].Revision < edits[j].Revision
+	})
+
+	return edits, nil
+}
+
+// applyChanges applies edits, tombstones, reply counts and reactions to messages loaded from the log.
+func (s *RedisStorage) applyChanges(ctx context.Context, roomID string, messages []*Message) error {
+	if err := s.applyEdits(ctx, roomID, messages); err != nil {
+		return err
+	}
+
+	if err := s.applyReplyCounts(ctx, roomID, messages); err != nil {
+		return err
+	}
+
+	return s.applyReactions(ctx, roomID, messages)
+}
+
+// applyEdits sets the text of edited messages to their last edit and replaces deleted messages with tombstones.
+func (s *RedisStorage) applyEdits(ctx context.Context, roomID string, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	lo, hi := messages[0].Number, messages[0].Number
+	for _, message := range messages {
+		lo, hi = min(lo, message.Number), max(hi, message.Number)
+	}
+
+	edits, err := s.loadEdits(ctx, roomID, lo, hi)
+	if err != nil || len(edits) == 0 {
+		return err
+	}
+
+	last := make(map[int]*messageEdit, len(edits))
+	for _, edit := range edits {
+		last[edit.Number] = edit
+	}
+
+	for i, message := range messages {
+		switch edit := last[message.Number]; {
+		case edit == nil:
+		case !edit.DeletedAt.IsZero():
+			messages[i] = tombstone(message, &Message{DeletedAt: edit.DeletedAt, DeletedBy: edit.DeletedBy})
+		default:
+			message.Text, message.EditedAt = edit.Text, edit.EditedAt
+		}
+	}
+
+	return nil
+}
+
+// applyReplyCounts counts the replies of threads rooted at the messages.
+func (s *RedisStorage) applyReplyCounts(ctx context.Context, roomID string, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	lo, hi := messages[0].Number, messages[0].Number
+	for _, message := range messages {
+		lo, hi = min(lo, message.Number), max(hi, message.Number)
+	}
+
+	replies, err := s.rdb.ZRangeByScoreWithScores(ctx, s.repliesKey(roomID), &redis.ZRangeBy{
+		Min: strconv.Itoa(lo),
+		Max: strconv.Itoa(hi),
+	}).Result()
+	if err != nil || len(replies) == 0 {
+		return err
+	}
+
+	counts := make(map[int]int)
+	for _, reply := range replies {
+		counts[int(reply.Score)]++
+	}
+
+	for _, message := range messages {
+		message.ReplyCount = counts[message.Number]
+	}
+
+	return nil
+}
+
+func (s *RedisStorage) editsKey(roomID string) string {
+	return fmt.Sprintf("%s:edits", roomID)
+}
+
+// applyReactions sets the reactions of the messages.
+func (s *RedisStorage) applyReactions(ctx context.Context, roomID string, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	lo, hi := messages[0].Number, messages[0].Number
+	for _, message := range messages {
+		lo, hi = min(lo, message.Number), max(hi, message.Number)
+	}
+
+	values, err := s.rdb.ZRangeByScore(ctx, s.reactionsKey(roomID), &redis.ZRangeBy{
+		Min: strconv.Itoa(lo),
+		Max: strconv.Itoa(hi),
+	}).Result()
+	if err != nil || len(values) == 0 {
+		return err
+	}
+
+	reactions := make(map[int][]*Reaction)
+	for _, value := range values {
+		var reaction reactionMember
+		if err = json.Unmarshal([]byte(value), &reaction); err != nil {
+			return fmt.Errorf("failed to unmarshal reaction: %w", err)
+		}
+
+		reactions[reaction.Number] = withReaction(reactions[reaction.Number], reaction.Emoji, reaction.UserID, true)
+	}
+
+	for _, message := range messages {
+		message.Reactions = reactions[message.Number]
+	}
+
+	return nil
+}
+
+func (s *RedisStorage) reactionsKey(roomID string) string {
+	return fmt.Sprintf("%s:reactions", roomID)
+}
+
+func (s *RedisStorage) presenceKey(roomID string) string {
+	return fmt.Sprintf("%s:presence", roomID)
+}
+
+func (s *RedisStorage) readCursorsKey(roomID string) string {
+	return fmt.Sprintf("%s:read_cursors", roomID)
+}
+
+func (s *RedisStorage) repliesKey(roomID string) string {
+	return fmt.Sprintf("%s:replies", roomID)
+}
+
+// inviteRoomID returns an empty room ID for unknown invites.
+func (s *RedisStorage) inviteRoomID(ctx context.Context, code string) (string, error) {
+	roomID, err := s.rdb.HGet(ctx, invitesIndexKey, code).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	return roomID, nil
+}
+
+func (s *RedisStorage) loadInvite(ctx context.Context, rdb redis.Cmdable, roomID, code string) (*Invite, error) {
+	res, err := rdb.HGet(ctx, s.invitesKey(roomID), code).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	var invite *Invite
+	if err = json.Unmarshal([]byte(res), &invite); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+func (s *RedisStorage) invitesKey(roomID string) string {
+	return fmt.Sprintf("%s:invites", roomID)
+}
+
+func (s *RedisStorage) inviteUsesKey(roomID string) string {
+	return fmt.Sprintf("%s:invites:uses", roomID)
+}
+
+func (s *RedisStorage) membersKey(roomID string) string {
+	return fmt.Sprintf("%s:members", roomID)
+}
+
+func (s *RedisStorage) membersIndexKey(roomID string) string {
+	return fmt.Sprintf("%s:members:index", roomID)
+}
+
+func (s *RedisStorage) restrictionsKey(roomID string) string {
+	return fmt.Sprintf("%s:restrictions", roomID)
+}
+
+func (s *RedisStorage) roomsOwnerIndexKey(ownerID string) string {
+	return fmt.Sprintf("rooms:index:owner:%s", ownerID)
+}
+
+func (s *RedisStorage) leaseKey(name string) string {
+	return fmt.Sprintf("leases:%s", name)
+}
+
+func (s *RedisStorage) roomEventsChannel(roomID string) string {
+	return fmt.Sprintf("%s:events", roomID)
+}
+
+// messageNumberKey is shared with the migrations, which run without a RedisStorage.
+func messageNumberKey(roomID string) string {
+	return fmt.Sprintf("%s:last_message_number", roomID)
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+	events chan *RoomEvent
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newRedisSubscription(pubsub *redis.PubSub) *redisSubscription {
+	sub := &redisSubscription{
+		pubsub: pubsub,
+		events: make(chan *RoomEvent),
+		done:   make(chan struct{}),
+	}
+
+	go sub.run()
+
+	return sub
+}
+
+func (s *redisSubscription) Events() <-chan *RoomEvent {
+	return s.events
+}
+
+func (s *redisSubscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.pubsub.Close()
+	})
+
+	return err
+}
+
+func (s *redisSubscription) run() {
+	defer close(s.events)
+
+	for msg := range s.pubsub.Channel() {
+		event := &RoomEvent{}
+		if err := event.UnmarshalBinary([]byte(msg.Payload)); err != nil {
+			slog.Error("failed to unmarshal room event", "channel", msg.Channel, "error", err)
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
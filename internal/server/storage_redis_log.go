@@ -0,0 +1,427 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RedisMessageLogSortedSet = "sorted-set"
+	RedisMessageLogStream    = "stream"
+)
+
+// redisMessageLog stores room messages under a single key per room. Every implementation
+// allocates message numbers from the same last message number key, records replies in the same
+// room replies sorted set and publishes the saved message to the room events channel, so the log
+// can be switched without touching the hubs.
+type redisMessageLog interface {
+	key(roomID string) string
+	// save allocates the next message number, stores the message and publishes it in a single atomic step.
+	save(ctx context.Context, rdb redis.Scripter, message *Message, numberKey, repliesKey, eventsChannel string) (int, error)
+	count(ctx context.Context, pipe redis.Pipeliner, roomID string) *redis.IntCmd
+	// latest queues loading of up to limit newest messages, or all of them when limit is 0,
+	// the returned function reads the result once the pipeline is executed.
+	latest(ctx context.Context, pipe redis.Pipeliner, roomID string, limit int) func() ([]*Message, error)
+	messageRange(ctx context.Context, rdb redis.Cmdable, roomID string, lo, hi int) ([]*Message, error)
+	// numbered queues loading of the messages with the given numbers, one command per number, the
+	// returned function reads the messages left in the log once the pipeline is executed.
+	numbered(ctx context.Context, pipe redis.Pipeliner, roomID string, numbers []int) func() ([]*Message, error)
+	bounds(ctx context.Context, rdb redis.Cmdable, roomID string) (firstNumber, lastNumber int, err error)
+	// trim removes all but the newest maxMessages messages and messages created before createdBefore,
+	// zero values disable the respective limit.
+	trim(ctx context.Context, rdb redis.Cmdable, roomID string, maxMessages int, createdBefore time.Time) (int, error)
+}
+
+// trimBatchSize is the number of the oldest messages inspected at once when trimming by age.
+const trimBatchSize = 100
+
+func newRedisMessageLog(name string, maxLen int) (redisMessageLog, error) {
+	switch name {
+	case "", RedisMessageLogSortedSet:
+		return sortedSetLog{}, nil
+	case RedisMessageLogStream:
+		return streamLog{maxLen: maxLen}, nil
+	default:
+		return nil, fmt.Errorf("unknown redis message log %q", name)
+	}
+}
+
+// saveMessageScript allocates the next message number, stores the message scored by its number
+// and publishes it to the room events channel. Replies are added to the room replies scored by
+// their thread root number and published with the thread reply count. The number is returned.
+//
+// KEYS[1] - room messages sorted set, KEYS[2] - room last message number, KEYS[3] - room replies sorted set.
+// ARGV[1] - message without number, ARGV[2] - room events channel.
+var saveMessageScript = redis.NewScript(`
+local number = tonumber(redis.call('GET', KEYS[2]) or '-1') + 1
+local message = cjson.decode(ARGV[1])
+message['Number'] = number
+
+redis.call('ZADD', KEYS[1], number, cjson.encode(message))
+redis.call('SET', KEYS[2], number)
+local replyCount = 0
+local replyTo = message['ReplyTo']
+if replyTo ~= nil and replyTo ~= cjson.null then
+	redis.call('ZADD', KEYS[3], replyTo, number)
+	replyCount = redis.call('ZCOUNT', KEYS[3], replyTo, replyTo)
+end
+redis.call('PUBLISH', ARGV[2], cjson.encode({type = 'message', message = message, reply_count = replyCount}))
+
+return number
+`)
+
+// sortedSetLog keeps JSON messages in a sorted set scored by their numbers.
+type sortedSetLog struct{}
+
+func (sortedSetLog) key(roomID string) string {
+	return fmt.Sprintf("%s:messages", roomID)
+}
+
+func (l sortedSetLog) save(ctx context.Context, rdb redis.Scripter, message *Message, numberKey, repliesKey, eventsChannel string) (int, error) {
+	keys := []string{l.key(message.RoomID), numberKey, repliesKey}
+	return saveMessageScript.Run(ctx, rdb, keys, message, eventsChannel).Int()
+}
+
+func (l sortedSetLog) count(ctx context.Context, pipe redis.Pipeliner, roomID string) *redis.IntCmd {
+	return pipe.ZCard(ctx, l.key(roomID))
+}
+
+func (l sortedSetLog) latest(ctx context.Context, pipe redis.Pipeliner, roomID string, limit int) func() ([]*Message, error) {
+	cmd := pipe.ZRevRange(ctx, l.key(roomID), 0, int64(limit-1))
+
+	return func() ([]*Message, error) {
+		var messages []*Message
+		if err := cmd.ScanSlice(&messages); err != nil {
+			return nil, err
+		}
+
+		return messages, nil
+	}
+}
+
+func (l sortedSetLog) messageRange(ctx context.Context, rdb redis.Cmdable, roomID string, lo, hi int) ([]*Message, error) {
+	var messages []*Message
+	err := rdb.ZRangeByScore(ctx, l.key(roomID), &redis.ZRangeBy{
+		Min: strconv.Itoa(lo),
+		Max: strconv.Itoa(hi),
+	}).ScanSlice(&messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (l sortedSetLog) numbered(ctx context.Context, pipe redis.Pipeliner, roomID string, numbers []int) func() ([]*Message, error) {
+	cmds := make([]*redis.StringSliceCmd, len(numbers))
+	for i, number := range numbers {
+		cmds[i] = pipe.ZRangeByScore(ctx, l.key(roomID), &redis.ZRangeBy{
+			Min: strconv.Itoa(number),
+			Max: strconv.Itoa(number),
+		})
+	}
+
+	return func() ([]*Message, error) {
+		messages := make([]*Message, 0, len(cmds))
+		for _, cmd := range cmds {
+			var found []*Message
+			if err := cmd.ScanSlice(&found); err != nil {
+				return nil, err
+			}
+
+			messages = append(messages, found...)
+		}
+
+		return messages, nil
+	}
+}
+
+func (l sortedSetLog) bounds(ctx context.Context, rdb redis.Cmdable, roomID string) (firstNumber, lastNumber int, err error) {
+	pipe := rdb.Pipeline()
+	firstCmd := pipe.ZRange(ctx, l.key(roomID), 0, 0)
+	lastCmd := pipe.ZRange(ctx, l.key(roomID), -1, -1)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	var first, last []*Message
+	if err = firstCmd.ScanSlice(&first); err != nil {
+		return 0, 0, err
+	}
+	if err = lastCmd.ScanSlice(&last); err != nil {
+		return 0, 0, err
+	}
+
+	if len(first) == 0 || len(last) == 0 {
+		return 0, -1, nil
+	}
+
+	return first[0].Number, last[0].Number, nil
+}
+
+func (l sortedSetLog) trim(ctx context.Context, rdb redis.Cmdable, roomID string, maxMessages int, createdBefore time.Time) (int, error) {
+	var removed int64
+	if maxMessages > 0 {
+		n, err := rdb.ZRemRangeByRank(ctx, l.key(roomID), 0, int64(-maxMessages-1)).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		removed += n
+	}
+
+	if createdBefore.IsZero() {
+		return int(removed), nil
+	}
+
+	// Scores are message numbers, so the oldest messages are inspected to find the last expired number.
+	for {
+		var messages []*Message
+		if err := rdb.ZRange(ctx, l.key(roomID), 0, trimBatchSize-1).ScanSlice(&messages); err != nil {
+			return int(removed), err
+		}
+
+		expired := countExpired(messages, createdBefore)
+		if expired == 0 {
+			return int(removed), nil
+		}
+
+		n, err := rdb.ZRemRangeByScore(ctx, l.key(roomID), "-inf", strconv.Itoa(messages[expired-1].Number)).Result()
+		if err != nil {
+			return int(removed), err
+		}
+
+		removed += n
+		if expired < len(messages) || n == 0 {
+			return int(removed), nil
+		}
+	}
+}
+
+// saveStreamMessageScript is saveMessageScript for streams, the entry ID is derived from the number.
+// The stream is trimmed to about ARGV[3] entries, 0 disables trimming.
+//
+// KEYS[1] - room messages stream, KEYS[2] - room last message number, KEYS[3] - room replies sorted set.
+// ARGV[1] - message without number, ARGV[2] - room events channel, ARGV[3] - stream max length.
+var saveStreamMessageScript = redis.NewScript(`
+local number = tonumber(redis.call('GET', KEYS[2]) or '-1') + 1
+local message = cjson.decode(ARGV[1])
+message['Number'] = number
+
+local id = number .. '-1'
+local maxLen = tonumber(ARGV[3])
+if maxLen > 0 then
+	redis.call('XADD', KEYS[1], 'MAXLEN', '~', maxLen, id, 'message', cjson.encode(message))
+else
+	redis.call('XADD', KEYS[1], id, 'message', cjson.encode(message))
+end
+redis.call('SET', KEYS[2], number)
+local replyCount = 0
+local replyTo = message['ReplyTo']
+if replyTo ~= nil and replyTo ~= cjson.null then
+	redis.call('ZADD', KEYS[3], replyTo, number)
+	replyCount = redis.call('ZCOUNT', KEYS[3], replyTo, replyTo)
+end
+redis.call('PUBLISH', ARGV[2], cjson.encode({type = 'message', message = message, reply_count = replyCount}))
+
+return number
+`)
+
+// streamMessageField is the stream entry field holding the JSON message.
+const streamMessageField = "message"
+
+// streamLog keeps messages in a stream. Entry IDs are "<number>-1", stream IDs can not be 0-0,
+// so entries are looked up by number directly and numbers can never collide.
+type streamLog struct {
+	maxLen int
+}
+
+func (streamLog) key(roomID string) string {
+	return fmt.Sprintf("%s:stream", roomID)
+}
+
+func (l streamLog) save(ctx context.Context, rdb redis.Scripter, message *Message, numberKey, repliesKey, eventsChannel string) (int, error) {
+	keys := []string{l.key(message.RoomID), numberKey, repliesKey}
+	return saveStreamMessageScript.Run(ctx, rdb, keys, message, eventsChannel, max(l.maxLen, 0)).Int()
+}
+
+func (l streamLog) count(ctx context.Context, pipe redis.Pipeliner, roomID string) *redis.IntCmd {
+	return pipe.XLen(ctx, l.key(roomID))
+}
+
+func (l streamLog) latest(ctx context.Context, pipe redis.Pipeliner, roomID string, limit int) func() ([]*Message, error) {
+	var cmd *redis.XMessageSliceCmd
+	if limit > 0 {
+		cmd = pipe.XRevRangeN(ctx, l.key(roomID), "+", "-", int64(limit))
+	} else {
+		cmd = pipe.XRevRange(ctx, l.key(roomID), "+", "-")
+	}
+
+	return func() ([]*Message, error) {
+		entries, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+
+		return streamMessages(entries)
+	}
+}
+
+func (l streamLog) messageRange(ctx context.Context, rdb redis.Cmdable, roomID string, lo, hi int) ([]*Message, error) {
+	entries, err := rdb.XRange(ctx, l.key(roomID), streamEntryID(max(lo, 0)), streamEntryID(hi)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return streamMessages(entries)
+}
+
+func (l streamLog) numbered(ctx context.Context, pipe redis.Pipeliner, roomID string, numbers []int) func() ([]*Message, error) {
+	cmds := make([]*redis.XMessageSliceCmd, len(numbers))
+	for i, number := range numbers {
+		cmds[i] = pipe.XRange(ctx, l.key(roomID), streamEntryID(number), streamEntryID(number))
+	}
+
+	return func() ([]*Message, error) {
+		messages := make([]*Message, 0, len(cmds))
+		for _, cmd := range cmds {
+			entries, err := cmd.Result()
+			if err != nil {
+				return nil, err
+			}
+
+			found, err := streamMessages(entries)
+			if err != nil {
+				return nil, err
+			}
+
+			messages = append(messages, found...)
+		}
+
+		return messages, nil
+	}
+}
+
+func (l streamLog) bounds(ctx context.Context, rdb redis.Cmdable, roomID string) (firstNumber, lastNumber int, err error) {
+	pipe := rdb.Pipeline()
+	firstCmd := pipe.XRangeN(ctx, l.key(roomID), "-", "+", 1)
+	lastCmd := pipe.XRevRangeN(ctx, l.key(roomID), "+", "-", 1)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	first, last := firstCmd.Val(), lastCmd.Val()
+	if len(first) == 0 || len(last) == 0 {
+		return 0, -1, nil
+	}
+
+	if firstNumber, err = streamEntryNumber(first[0].ID); err != nil {
+		return 0, 0, err
+	}
+	if lastNumber, err = streamEntryNumber(last[0].ID); err != nil {
+		return 0, 0, err
+	}
+
+	return firstNumber, lastNumber, nil
+}
+
+func (l streamLog) trim(ctx context.Context, rdb redis.Cmdable, roomID string, maxMessages int, createdBefore time.Time) (int, error) {
+	var removed int64
+	if maxMessages > 0 {
+		n, err := rdb.XTrimMaxLen(ctx, l.key(roomID), int64(maxMessages)).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		removed += n
+	}
+
+	if createdBefore.IsZero() {
+		return int(removed), nil
+	}
+
+	for {
+		entries, err := rdb.XRangeN(ctx, l.key(roomID), "-", "+", trimBatchSize).Result()
+		if err != nil {
+			return int(removed), err
+		}
+
+		messages, err := streamMessages(entries)
+		if err != nil {
+			return int(removed), err
+		}
+
+		expired := countExpired(messages, createdBefore)
+		if expired == 0 {
+			return int(removed), nil
+		}
+
+		n, err := rdb.XTrimMinID(ctx, l.key(roomID), streamEntryID(messages[expired-1].Number+1)).Result()
+		if err != nil {
+			return int(removed), err
+		}
+
+		removed += n
+		if expired < len(messages) || n == 0 {
+			return int(removed), nil
+		}
+	}
+}
+
+// countExpired returns the number of leading messages created before createdBefore.
+func countExpired(messages []*Message, createdBefore time.Time) int {
+	for i, message := range messages {
+		if !message.CreatedAt.Before(createdBefore) {
+			return i
+		}
+	}
+
+	return len(messages)
+}
+
+func streamEntryID(number int) string {
+	return strconv.Itoa(number) + "-1"
+}
+
+func streamEntryNumber(id string) (int, error) {
+	number, _, _ := strings.Cut(id, "-")
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stream entry id %q: %w", id, err)
+	}
+
+	return n, nil
+}
+
+func streamMessages(entries []redis.XMessage) ([]*Message, error) {
+	messages := make([]*Message, 0, len(entries))
+	for _, entry := range entries {
+		message, err := streamMessage(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func streamMessage(entry redis.XMessage) (*Message, error) {
+	value, ok := entry.Values[streamMessageField].(string)
+	if !ok {
+		return nil, fmt.Errorf("stream entry %s has no message", entry.ID)
+	}
+
+	message := &Message{}
+	if err := message.UnmarshalBinary([]byte(value)); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stream entry %s: %w", entry.ID, err)
+	}
+
+	return message, nil
+}
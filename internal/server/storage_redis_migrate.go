@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const migrationBatchSize = 500
+
+// MigrationReport summarizes a message log migration.
+type MigrationReport struct {
+	Rooms    int
+	Messages int
+	// Skipped counts messages that were not migrated because another message has the same number.
+	Skipped int
+}
+
+// MigrateMessagesToStreams copies messages of every room from the sorted set log to the stream log.
+// It is idempotent: messages already present in a stream are skipped, so an interrupted migration
+// can be resumed. Messages saved to sorted sets after the last run are not copied, so run it
+// once more right before switching Config.RedisMessageLog to "stream". When deleteSource is set,
+// sorted sets are deleted once their messages are copied, sorted sets with skipped messages are kept.
+//
+// Sorted sets written before messages were scored by number are supported, messages are ordered
+// by their numbers rather than by scores.
+func MigrateMessagesToStreams(ctx context.Context, rdb *redis.Client, deleteSource bool) (*MigrationReport, error) {
+	from, to := sortedSetLog{}, streamLog{}
+	report := &MigrationReport{}
+
+	iter := rdb.Scan(ctx, 0, from.key("rooms:*:data"), migrationBatchSize).Iterator()
+	for iter.Next(ctx) {
+		roomID := strings.TrimSuffix(iter.Val(), ":messages")
+
+		migrated, skipped, err := migrateRoomMessages(ctx, rdb, from, to, roomID)
+		if err != nil {
+			return report, fmt.Errorf("failed to migrate room %s: %w", roomID, err)
+		}
+
+		switch {
+		case deleteSource && skipped > 0:
+			slog.Warn("room sorted set kept with skipped messages", "room_id", roomID, "skipped", skipped)
+		case deleteSource:
+			if err = rdb.Del(ctx, from.key(roomID)).Err(); err != nil {
+				return report, fmt.Errorf("failed to delete room %s sorted set: %w", roomID, err)
+			}
+		}
+
+		slog.Info("room messages migrated", "room_id", roomID, "messages", migrated, "skipped", skipped)
+
+		report.Rooms++
+		report.Messages += migrated
+		report.Skipped += skipped
+	}
+
+	if err := iter.Err(); err != nil {
+		return report, fmt.Errorf("failed to scan rooms: %w", err)
+	}
+
+	return report, nil
+}
+
+// migrateRoomMessages returns the number of copied messages and of messages skipped as duplicates.
+func migrateRoomMessages(ctx context.Context, rdb *redis.Client, from sortedSetLog, to streamLog, roomID string) (migrated, skipped int, err error) {
+	var messages []*Message
+	if err = rdb.ZRange(ctx, from.key(roomID), 0, -1).ScanSlice(&messages); err != nil {
+		return 0, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Number < messages[j].Number
+	})
+
+	_, lastNumber, err := to.bounds(ctx, rdb, roomID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load stream bounds: %w", err)
+	}
+
+	pipe := rdb.Pipeline()
+	for i, message := range messages {
+		// Numbers could be duplicated by the old timestamp based allocation, the first message wins.
+		if i > 0 && message.Number == messages[i-1].Number {
+			slog.Warn("duplicate message number skipped", "room_id", roomID, "number", message.Number, "created_at", message.CreatedAt)
+			skipped++
+			continue
+		}
+
+		// Messages up to the last stream entry were copied by a previous run.
+		if message.Number <= lastNumber {
+			continue
+		}
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: to.key(roomID),
+			ID:     streamEntryID(message.Number),
+			Values: []any{streamMessageField, message},
+		})
+
+		lastNumber = message.Number
+		migrated++
+
+		if pipe.Len() >= migrationBatchSize {
+			if _, err = pipe.Exec(ctx); err != nil {
+				return 0, 0, fmt.Errorf("failed to add messages: %w", err)
+			}
+		}
+	}
+
+	if _, err = pipe.Exec(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to add messages: %w", err)
+	}
+
+	if err = raiseMessageNumber(ctx, rdb, roomID, lastNumber); err != nil {
+		return 0, 0, err
+	}
+
+	return migrated, skipped, nil
+}
+
+// RescoreSortedSetMessages scores messages of every room sorted set log by their numbers. Sorted sets
+// written before messages were scored by number are scored by their creation time in nanoseconds, which
+// breaks number based ranges and trimming, so run it once before serving such rooms with this version.
+// It is idempotent, messages already scored by their numbers are left in place.
+func RescoreSortedSetMessages(ctx context.Context, rdb *redis.Client) (*MigrationReport, error) {
+	log := sortedSetLog{}
+	report := &MigrationReport{}
+
+	iter := rdb.Scan(ctx, 0, log.key("rooms:*:data"), migrationBatchSize).Iterator()
+	for iter.Next(ctx) {
+		roomID := strings.TrimSuffix(iter.Val(), ":messages")
+
+		rescored, err := rescoreRoomMessages(ctx, rdb, log, roomID)
+		if err != nil {
+			return report, fmt.Errorf("failed to rescore room %s: %w", roomID, err)
+		}
+
+		if rescored > 0 {
+			slog.Info("room messages rescored", "room_id", roomID, "messages", rescored)
+		}
+
+		report.Rooms++
+		report.Messages += rescored
+	}
+
+	if err := iter.Err(); err != nil {
+		return report, fmt.Errorf("failed to scan rooms: %w", err)
+	}
+
+	return report, nil
+}
+
+func rescoreRoomMessages(ctx context.Context, rdb *redis.Client, log sortedSetLog, roomID string) (int, error) {
+	rescored := 0
+	lastNumber := -1
+
+	// Changing scores moves members around, ZSCAN still returns every member present for the whole scan.
+	iter := rdb.ZScan(ctx, log.key(roomID), 0, "", migrationBatchSize).Iterator()
+	pipe := rdb.Pipeline()
+	for iter.Next(ctx) {
+		member := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+
+		score, err := strconv.ParseFloat(iter.Val(), 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse score: %w", err)
+		}
+
+		var message Message
+		if err = message.UnmarshalBinary([]byte(member)); err != nil {
+			return 0, fmt.Errorf("failed to decode message: %w", err)
+		}
+
+		lastNumber = max(lastNumber, message.Number)
+		if score == float64(message.Number) {
+			continue
+		}
+
+		pipe.ZAdd(ctx, log.key(roomID), redis.Z{Score: float64(message.Number), Member: member})
+		rescored++
+
+		if pipe.Len() >= migrationBatchSize {
+			if _, err = pipe.Exec(ctx); err != nil {
+				return 0, fmt.Errorf("failed to rescore messages: %w", err)
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to scan messages: %w", err)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to rescore messages: %w", err)
+	}
+
+	if err := raiseMessageNumber(ctx, rdb, roomID, lastNumber); err != nil {
+		return 0, err
+	}
+
+	return rescored, nil
+}
+
+// raiseMessageNumber makes sure new messages are numbered after the migrated ones.
+func raiseMessageNumber(ctx context.Context, rdb *redis.Client, roomID string, number int) error {
+	if number < 0 {
+		return nil
+	}
+
+	key := messageNumberKey(roomID)
+	err := watch(ctx, rdb, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Int()
+		switch {
+		case errors.Is(err, redis.Nil):
+			current = -1
+		case err != nil:
+			return err
+		}
+
+		if current >= number {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, number, 0)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to update last message number: %w", err)
+	}
+
+	return nil
+}
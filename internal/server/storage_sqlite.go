@@ -0,0 +1,1365 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// Registers the pure Go "sqlite" database/sql driver.
+	_ "modernc.org/sqlite"
+)
+
+var _ Storage = (*SQLiteStorage)(nil)
+
+// sqliteMigrations are applied in order, PRAGMA user_version holds the number of applied ones.
+// Existing migrations must never be changed, add a new one instead.
+var sqliteMigrations = []string{
+	`CREATE TABLE rooms (
+		id                  TEXT PRIMARY KEY,
+		owner_id            TEXT NOT NULL,
+		name                TEXT NOT NULL,
+		order_key           TEXT NOT NULL,
+		created_at          INTEGER NOT NULL,
+		archived            INTEGER NOT NULL DEFAULT 0,
+		last_message_number INTEGER NOT NULL DEFAULT -1
+	);
+	CREATE INDEX rooms_order_key_idx ON rooms (order_key);
+	CREATE INDEX rooms_owner_order_key_idx ON rooms (owner_id, order_key);
+
+	CREATE TABLE messages (
+		room_id    TEXT NOT NULL,
+		number     INTEGER NOT NULL,
+		user_id    TEXT NOT NULL,
+		text       TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (room_id, number)
+	) WITHOUT ROWID;
+	CREATE INDEX messages_room_created_at_idx ON messages (room_id, created_at);`,
+
+	`ALTER TABLE rooms ADD COLUMN max_messages INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE rooms ADD COLUMN max_retention INTEGER NOT NULL DEFAULT 0;`,
+
+	`ALTER TABLE rooms ADD COLUMN private INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE members (
+		room_id   TEXT NOT NULL,
+		user_id   TEXT NOT NULL,
+		joined_at INTEGER NOT NULL,
+		PRIMARY KEY (room_id, user_id)
+	) WITHOUT ROWID;
+	INSERT INTO members (room_id, user_id, joined_at) SELECT id, owner_id, created_at FROM rooms;`,
+
+	`ALTER TABLE members ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+	UPDATE members SET role = 'owner' WHERE (room_id, user_id) IN (SELECT id, owner_id FROM rooms);`,
+
+	`CREATE TABLE invites (
+		code       TEXT PRIMARY KEY,
+		room_id    TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		max_uses   INTEGER NOT NULL,
+		uses       INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX invites_room_id_idx ON invites (room_id);
+
+	CREATE TABLE invite_uses (
+		id         INTEGER PRIMARY KEY,
+		room_id    TEXT NOT NULL,
+		code       TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		used_at    INTEGER NOT NULL
+	);
+	CREATE INDEX invite_uses_room_id_idx ON invite_uses (room_id, id);`,
+
+	`ALTER TABLE messages ADD COLUMN edited_at INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE message_revisions (
+		room_id    TEXT NOT NULL,
+		number     INTEGER NOT NULL,
+		revision   INTEGER NOT NULL,
+		text       TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (room_id, number, revision),
+		FOREIGN KEY (room_id, number) REFERENCES messages (room_id, number) ON DELETE CASCADE
+	) WITHOUT ROWID;`,
+
+	`ALTER TABLE messages ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE messages ADD COLUMN reply_to INTEGER;
+	ALTER TABLE messages ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX messages_room_reply_to_idx ON messages (room_id, reply_to, number) WHERE reply_to IS NOT NULL;`,
+
+	`CREATE TABLE reactions (
+		room_id    TEXT NOT NULL,
+		number     INTEGER NOT NULL,
+		emoji      TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (room_id, number, emoji, user_id),
+		FOREIGN KEY (room_id, number) REFERENCES messages (room_id, number) ON DELETE CASCADE
+	) WITHOUT ROWID;`,
+
+	`CREATE TABLE read_cursors (
+		room_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		number  INTEGER NOT NULL,
+		read_at INTEGER NOT NULL,
+		PRIMARY KEY (room_id, user_id)
+	) WITHOUT ROWID;`,
+
+	`CREATE TABLE restrictions (
+		room_id     TEXT NOT NULL,
+		user_id     TEXT NOT NULL,
+		restriction TEXT NOT NULL,
+		PRIMARY KEY (room_id, user_id)
+	) WITHOUT ROWID;`,
+}
+
+// SQLiteStorage keeps rooms and messages in an embedded SQLite database. Events are delivered
+// within the process only, so the database must not be shared between server instances.
+type SQLiteStorage struct {
+	db *sql.DB
+
+	events   *localEvents
+	leases   *localLeases
+	presence *localPresence
+}
+
+// NewSQLiteStorage opens the database at path and migrates its schema. Messages beyond
+// the retention limits are deleted by TrimMessages.
+func NewSQLiteStorage(ctx context.Context, path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	// SQLite allows a single writer, a single connection avoids busy errors and keeps
+	// an in-memory database alive.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStorage{
+		db:       db,
+		events:   newLocalEvents(),
+		leases:   newLocalLeases(),
+		presence: newLocalPresence(),
+	}
+
+	if err = s.migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// sqliteDSN enables the write-ahead log and foreign keys for every connection the pool opens, the cascade
+// deletes revisions and reactions of trimmed messages.
+func sqliteDSN(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	return path + separator + "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)"
+}
+
+func (s *SQLiteStorage) CreateRoom(ctx context.Context, room *Room) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO rooms (id, owner_id, name, order_key, created_at, archived, private, max_messages, max_retention)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			room.ID, room.OwnerID, room.Name, roomOrderKey(room), room.CreatedAt.UnixNano(), room.Archived, room.Private,
+			room.MaxMessages, room.MaxRetention,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO members (room_id, user_id, joined_at, role) VALUES (?, ?, ?, ?)`,
+			room.ID, room.OwnerID, room.CreatedAt.UnixNano(), RoleOwner,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create room: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetRooms(ctx context.Context, roomIDs []string) ([]*RoomInfo, error) {
+	infos := make([]*RoomInfo, len(roomIDs))
+	for i, roomID := range roomIDs {
+		row := s.db.QueryRowContext(ctx, `SELECT `+sqliteRoomInfoColumns+` FROM rooms WHERE id = ?`, roomID)
+
+		info, err := scanRoomInfo(row)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed to load room: %w", err)
+		}
+
+		infos[i] = info
+	}
+
+	return infos, nil
+}
+
+func (s *SQLiteStorage) ListRooms(ctx context.Context, filter RoomFilter, pageSize int, pageToken string) (rooms []*RoomInfo, nextPageToken string, err error) {
+	var after string
+	if pageToken != "" {
+		if after, err = decodePageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	prefix := strings.ToLower(filter.NamePrefix)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+sqliteRoomInfoColumns+`, order_key FROM rooms
+		WHERE (? = '' OR owner_id = ?) AND order_key >= ? AND order_key < ? AND order_key > ?
+			AND (? = '' OR private = 0 OR owner_id = ? OR EXISTS (SELECT 1 FROM members WHERE members.room_id = rooms.id AND members.user_id = ?))
+		ORDER BY order_key LIMIT ?`,
+		filter.OwnerID, filter.OwnerID, prefix, prefix+"\xff", after,
+		filter.VisibleTo, filter.VisibleTo, filter.VisibleTo, pageSize+1,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list rooms: %w", err)
+	}
+	defer rows.Close()
+
+	var orderKeys []string
+	for rows.Next() {
+		var orderKey string
+		info, scanErr := scanRoomInfo(rows, &orderKey)
+		if scanErr != nil {
+			return nil, "", fmt.Errorf("failed to list rooms: %w", scanErr)
+		}
+
+		rooms = append(rooms, info)
+		orderKeys = append(orderKeys, orderKey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to list rooms: %w", err)
+	}
+
+	if len(rooms) > pageSize {
+		rooms = rooms[:pageSize]
+		nextPageToken = encodePageToken(orderKeys[pageSize-1])
+	}
+
+	return rooms, nextPageToken, nil
+}
+
+func (s *SQLiteStorage) UpdateRoom(ctx context.Context, roomID string, update func(room *Room) error) (*Room, error) {
+	var room *Room
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			room, err = s.loadRoom(ctx, tx, roomID)
+			if err != nil {
+				return err
+			}
+
+			if err = update(room); err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`UPDATE rooms SET name = ?, order_key = ?, archived = ?, private = ?, max_messages = ?, max_retention = ? WHERE id = ?`,
+				room.Name, roomOrderKey(room), room.Archived, room.Private, room.MaxMessages, room.MaxRetention, room.ID,
+			)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		published := *room
+		return &RoomEvent{Type: RoomEventRoomUpdated, Room: &published}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update room: %w", err)
+	}
+
+	return room, nil
+}
+
+func (s *SQLiteStorage) DeleteRoom(ctx context.Context, roomID string, check func(room *Room) error) error {
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			room, err := s.loadRoom(ctx, tx, roomID)
+			if err != nil {
+				return err
+			}
+
+			if err = check(room); err != nil {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, `DELETE FROM messages WHERE room_id = ?`, roomID); err != nil {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, `DELETE FROM members WHERE room_id = ?`, roomID); err != nil {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, `DELETE FROM restrictions WHERE room_id = ?`, roomID); err != nil {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, `DELETE FROM invites WHERE room_id = ?`, roomID); err != nil {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, `DELETE FROM invite_uses WHERE room_id = ?`, roomID); err != nil {
+				return err
+			}
+
+			if _, err = tx.ExecContext(ctx, `DELETE FROM read_cursors WHERE room_id = ?`, roomID); err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `DELETE FROM rooms WHERE id = ?`, roomID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &RoomEvent{Type: RoomEventRoomDeleted}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) AddMember(ctx context.Context, member *Member) (bool, error) {
+	var added bool
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.loadRoom(ctx, tx, member.RoomID); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO members (room_id, user_id, joined_at, role) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			member.RoomID, member.UserID, member.JoinedAt.UnixNano(), member.Role,
+		)
+		if err != nil {
+			return err
+		}
+
+		n, _ := res.RowsAffected()
+		added = n > 0
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	return added, nil
+}
+
+func (s *SQLiteStorage) RemoveMember(ctx context.Context, roomID, userID string) (bool, error) {
+	var removed bool
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		var joinedAt int64
+		var role Role
+		err := s.db.QueryRowContext(ctx,
+			`DELETE FROM members WHERE room_id = ? AND user_id = ? RETURNING joined_at, role`, roomID, userID,
+		).Scan(&joinedAt, &role)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+
+		removed = true
+		member := &Member{RoomID: roomID, UserID: userID, JoinedAt: time.Unix(0, joinedAt), Role: role}
+		return &RoomEvent{Type: RoomEventMemberRemoved, Member: member}, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	return removed, nil
+}
+
+func (s *SQLiteStorage) UpdateMember(ctx context.Context, roomID, userID string, update func(member *Member) error) (*Member, error) {
+	var member *Member
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			member, err = s.loadMember(ctx, tx, roomID, userID)
+			if err != nil {
+				return err
+			}
+
+			if member == nil {
+				return ErrNotMember
+			}
+
+			if err = update(member); err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`UPDATE members SET role = ? WHERE room_id = ? AND user_id = ?`, member.Role, roomID, userID,
+			)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		published := *member
+		return &RoomEvent{Type: RoomEventMemberUpdated, Member: &published}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update member: %w", err)
+	}
+
+	return member, nil
+}
+
+func (s *SQLiteStorage) SetRestriction(ctx context.Context, roomID, userID string, restriction Restriction) error {
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := s.loadRoom(ctx, tx, roomID); err != nil {
+				return err
+			}
+
+			if restriction == RestrictionNone {
+				_, err := tx.ExecContext(ctx, `DELETE FROM restrictions WHERE room_id = ? AND user_id = ?`, roomID, userID)
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO restrictions (room_id, user_id, restriction) VALUES (?, ?, ?)
+				ON CONFLICT (room_id, user_id) DO UPDATE SET restriction = excluded.restriction`,
+				roomID, userID, string(restriction),
+			)
+			return err
+		})
+		if err != nil || restriction != RestrictionBanned {
+			return nil, err
+		}
+
+		return &RoomEvent{Type: RoomEventMemberRemoved, Member: &Member{RoomID: roomID, UserID: userID}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set restriction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetRestriction(ctx context.Context, roomID, userID string) (Restriction, error) {
+	var restriction string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT restriction FROM restrictions WHERE room_id = ? AND user_id = ?`, roomID, userID,
+	).Scan(&restriction)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return RestrictionNone, nil
+	case err != nil:
+		return RestrictionNone, fmt.Errorf("failed to get restriction: %w", err)
+	}
+
+	return Restriction(restriction), nil
+}
+
+func (s *SQLiteStorage) GetMember(ctx context.Context, roomID, userID string) (*Member, error) {
+	member, err := s.loadMember(ctx, s.db, roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	return member, nil
+}
+
+func (s *SQLiteStorage) ListMembers(ctx context.Context, roomID string, pageSize int, pageToken string) (members []*Member, nextPageToken string, err error) {
+	var after string
+	if pageToken != "" {
+		if after, err = decodePageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT user_id, joined_at, role FROM members WHERE room_id = ? AND user_id > ? ORDER BY user_id LIMIT ?`,
+		roomID, after, pageSize+1,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list members: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var joinedAt int64
+		member := &Member{RoomID: roomID}
+		if err = rows.Scan(&member.UserID, &joinedAt, &member.Role); err != nil {
+			return nil, "", fmt.Errorf("failed to list members: %w", err)
+		}
+
+		member.JoinedAt = time.Unix(0, joinedAt)
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to list members: %w", err)
+	}
+
+	if len(members) > pageSize {
+		members = members[:pageSize]
+		nextPageToken = encodePageToken(members[pageSize-1].UserID)
+	}
+
+	return members, nextPageToken, nil
+}
+
+func (s *SQLiteStorage) CreateInvite(ctx context.Context, invite *Invite) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.loadRoom(ctx, tx, invite.RoomID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO invites (code, room_id, created_by, created_at, expires_at, max_uses, uses) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			invite.Code, invite.RoomID, invite.CreatedBy, invite.CreatedAt.UnixNano(), invite.ExpiresAt.UnixNano(),
+			invite.MaxUses, invite.Uses,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetInvite(ctx context.Context, code string) (*Invite, error) {
+	invite, err := s.loadInvite(ctx, s.db, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+func (s *SQLiteStorage) DeleteInvite(ctx context.Context, code string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM invites WHERE code = ?`, code)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete invite: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *SQLiteStorage) DeleteExpiredInvites(ctx context.Context, roomID string, expiredBefore time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM invites WHERE room_id = ? AND expires_at < ?`, roomID, expiredBefore.UnixNano(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired invites: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (s *SQLiteStorage) JoinWithInvite(ctx context.Context, code, userID string, join func(invite *Invite) (*Member, error)) (*Member, bool, error) {
+	var member *Member
+	var added bool
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		invite, err := s.loadInvite(ctx, tx, code)
+		if err != nil {
+			return err
+		}
+
+		if invite == nil {
+			return ErrInviteNotFound
+		}
+
+		if member, err = s.loadMember(ctx, tx, invite.RoomID, userID); err != nil || member != nil {
+			return err
+		}
+
+		if member, err = join(invite); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO members (room_id, user_id, joined_at, role) VALUES (?, ?, ?, ?)`,
+			member.RoomID, member.UserID, member.JoinedAt.UnixNano(), member.Role,
+		)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, `UPDATE invites SET uses = uses + 1 WHERE code = ?`, code); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO invite_uses (room_id, code, user_id, created_by, used_at) VALUES (?, ?, ?, ?, ?)`,
+			invite.RoomID, code, member.UserID, invite.CreatedBy, member.JoinedAt.UnixNano(),
+		)
+		added = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to join with invite: %w", err)
+	}
+
+	return member, added, nil
+}
+
+func (s *SQLiteStorage) ListInviteUses(ctx context.Context, roomID string, pageSize int, pageToken string) (uses []*InviteUse, nextPageToken string, err error) {
+	var after int64
+	if pageToken != "" {
+		if after, err = decodePositionPageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, code, user_id, created_by, used_at FROM invite_uses WHERE room_id = ? AND id > ? ORDER BY id LIMIT ?`,
+		roomID, after, pageSize+1,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list invite uses: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id, usedAt int64
+		use := &InviteUse{RoomID: roomID}
+		if err = rows.Scan(&id, &use.Code, &use.UserID, &use.CreatedBy, &usedAt); err != nil {
+			return nil, "", fmt.Errorf("failed to list invite uses: %w", err)
+		}
+
+		use.UsedAt = time.Unix(0, usedAt)
+		uses = append(uses, use)
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to list invite uses: %w", err)
+	}
+
+	if len(uses) > pageSize {
+		uses = uses[:pageSize]
+		nextPageToken = encodePositionPageToken(ids[pageSize-1])
+	}
+
+	return uses, nextPageToken, nil
+}
+
+// SaveMessage allocates the number from the room row in the same transaction.
+func (s *SQLiteStorage) SaveMessage(ctx context.Context, message *Message) error {
+	err := s.events.publishAfter(message.RoomID, func() (*RoomEvent, error) {
+		var replyCount int
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			err := tx.QueryRowContext(ctx,
+				`UPDATE rooms SET last_message_number = last_message_number + 1 WHERE id = ? RETURNING last_message_number`,
+				message.RoomID,
+			).Scan(&message.Number)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRoomNotFound
+			case err != nil:
+				return err
+			}
+
+			if message.ReplyTo != nil {
+				err = tx.QueryRowContext(ctx,
+					`UPDATE messages SET reply_count = reply_count + 1 WHERE room_id = ? AND number = ? RETURNING reply_count`,
+					message.RoomID, *message.ReplyTo,
+				).Scan(&replyCount)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return err
+				}
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO messages (room_id, number, user_id, text, created_at, reply_to) VALUES (?, ?, ?, ?, ?, ?)`,
+				message.RoomID, message.Number, message.UserID, message.Text, message.CreatedAt.UnixNano(), message.ReplyTo,
+			)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		published := *message
+		return &RoomEvent{Type: RoomEventMessage, Message: &published, ReplyCount: replyCount}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) EditMessage(ctx context.Context, message *Message) error {
+	err := s.events.publishAfter(message.RoomID, func() (*RoomEvent, error) {
+		var edited *Message
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			edited, err = s.loadMessage(ctx, tx, message.RoomID, message.Number)
+			if err != nil {
+				return err
+			}
+
+			written := edited.CreatedAt
+			if !edited.EditedAt.IsZero() {
+				written = edited.EditedAt
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`INSERT INTO message_revisions (room_id, number, revision, text, created_at)
+				SELECT ?, ?, COUNT(*) + 1, ?, ? FROM message_revisions WHERE room_id = ? AND number = ?`,
+				message.RoomID, message.Number, edited.Text, written.UnixNano(), message.RoomID, message.Number,
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`UPDATE messages SET text = ?, edited_at = ? WHERE room_id = ? AND number = ?`,
+				message.Text, message.EditedAt.UnixNano(), message.RoomID, message.Number,
+			)
+
+			edited.Text, edited.EditedAt = message.Text, message.EditedAt
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &RoomEvent{Type: RoomEventMessageEdited, Message: edited}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteMessage(ctx context.Context, message *Message) error {
+	err := s.events.publishAfter(message.RoomID, func() (*RoomEvent, error) {
+		var deleted *Message
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			deleted, err = s.loadMessage(ctx, tx, message.RoomID, message.Number)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`DELETE FROM message_revisions WHERE room_id = ? AND number = ?`, message.RoomID, message.Number,
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`DELETE FROM reactions WHERE room_id = ? AND number = ?`, message.RoomID, message.Number,
+			)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx,
+				`UPDATE messages SET text = '', edited_at = 0, deleted_at = ?, deleted_by = ? WHERE room_id = ? AND number = ?`,
+				message.DeletedAt.UnixNano(), message.DeletedBy, message.RoomID, message.Number,
+			)
+
+			deleted = tombstone(deleted, message)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &RoomEvent{Type: RoomEventMessageDeleted, Message: deleted}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) LoadMessageRevisions(ctx context.Context, roomID string, number int) ([]*MessageRevision, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM messages WHERE room_id = ? AND number = ?)`, roomID, number,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message revisions: %w", err)
+	}
+
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT text, created_at FROM message_revisions WHERE room_id = ? AND number = ? ORDER BY revision`,
+		roomID, number,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := make([]*MessageRevision, 0)
+	for rows.Next() {
+		var createdAt int64
+		revision := &MessageRevision{}
+		if err = rows.Scan(&revision.Text, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to load message revisions: %w", err)
+		}
+
+		revision.CreatedAt = time.Unix(0, createdAt)
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load message revisions: %w", err)
+	}
+
+	return revisions, nil
+}
+
+func (s *SQLiteStorage) AddReaction(ctx context.Context, roomID string, number int, emoji, userID string) (bool, error) {
+	added, err := s.updateReaction(ctx, roomID, number, emoji, userID, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+
+	return added, nil
+}
+
+func (s *SQLiteStorage) RemoveReaction(ctx context.Context, roomID string, number int, emoji, userID string) (bool, error) {
+	removed, err := s.updateReaction(ctx, roomID, number, emoji, userID, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+
+	return removed, nil
+}
+
+func (s *SQLiteStorage) updateReaction(ctx context.Context, roomID string, number int, emoji, userID string, added bool) (bool, error) {
+	var changed bool
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		update := &ReactionUpdate{Number: number, Emoji: emoji, UserID: userID, Added: added}
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			var result sql.Result
+			var err error
+			if added {
+				if _, err = s.loadMessage(ctx, tx, roomID, number); err != nil {
+					return err
+				}
+
+				result, err = tx.ExecContext(ctx,
+					`INSERT INTO reactions (room_id, number, emoji, user_id, created_at) VALUES (?, ?, ?, ?, ?)
+					ON CONFLICT DO NOTHING`,
+					roomID, number, emoji, userID, time.Now().UnixNano(),
+				)
+			} else {
+				result, err = tx.ExecContext(ctx,
+					`DELETE FROM reactions WHERE room_id = ? AND number = ? AND emoji = ? AND user_id = ?`,
+					roomID, number, emoji, userID,
+				)
+			}
+			if err != nil {
+				return err
+			}
+
+			affected, err := result.RowsAffected()
+			if err != nil || affected == 0 {
+				return err
+			}
+
+			changed = true
+			return tx.QueryRowContext(ctx,
+				`SELECT COUNT(*) FROM reactions WHERE room_id = ? AND number = ? AND emoji = ?`, roomID, number, emoji,
+			).Scan(&update.Count)
+		})
+		if err != nil || !changed {
+			return nil, err
+		}
+
+		return &RoomEvent{Type: RoomEventReaction, Reaction: update}, nil
+	})
+
+	return changed, err
+}
+
+func (s *SQLiteStorage) MarkRead(ctx context.Context, roomID string, receipt *ReadReceipt) (bool, error) {
+	var moved bool
+	err := s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := s.loadRoom(ctx, tx, roomID); err != nil {
+				return err
+			}
+
+			result, err := tx.ExecContext(ctx,
+				`INSERT INTO read_cursors (room_id, user_id, number, read_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (room_id, user_id) DO UPDATE SET number = excluded.number, read_at = excluded.read_at
+				WHERE excluded.number > read_cursors.number`,
+				roomID, receipt.UserID, receipt.Number, receipt.ReadAt.UnixNano(),
+			)
+			if err != nil {
+				return err
+			}
+
+			affected, err := result.RowsAffected()
+			moved = affected > 0
+			return err
+		})
+		if err != nil || !moved {
+			return nil, err
+		}
+
+		return &RoomEvent{Type: RoomEventReadReceipt, ReadReceipt: receipt}, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark read: %w", err)
+	}
+
+	return moved, nil
+}
+
+func (s *SQLiteStorage) GetReadReceipt(ctx context.Context, roomID, userID string) (*ReadReceipt, error) {
+	var readAt int64
+	receipt := &ReadReceipt{UserID: userID}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT number, read_at FROM read_cursors WHERE room_id = ? AND user_id = ?`, roomID, userID,
+	).Scan(&receipt.Number, &readAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get read receipt: %w", err)
+	}
+
+	receipt.ReadAt = time.Unix(0, readAt)
+	return receipt, nil
+}
+
+func (s *SQLiteStorage) ListReadReceipts(ctx context.Context, roomID string) ([]*ReadReceipt, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT user_id, number, read_at FROM read_cursors WHERE room_id = ? ORDER BY user_id`, roomID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list read receipts: %w", err)
+	}
+	defer rows.Close()
+
+	var receipts []*ReadReceipt
+	for rows.Next() {
+		var readAt int64
+		receipt := &ReadReceipt{}
+		if err = rows.Scan(&receipt.UserID, &receipt.Number, &readAt); err != nil {
+			return nil, fmt.Errorf("failed to list read receipts: %w", err)
+		}
+
+		receipt.ReadAt = time.Unix(0, readAt)
+		receipts = append(receipts, receipt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list read receipts: %w", err)
+	}
+
+	return receipts, nil
+}
+
+func (s *SQLiteStorage) LoadThread(ctx context.Context, roomID string, number, after, limit int) ([]*Message, error) {
+	replies, err := s.queryMessages(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE room_id = ? AND reply_to = ? AND number > ? ORDER BY number LIMIT ?`,
+		roomID, number, after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	return replies, nil
+}
+
+func (s *SQLiteStorage) LoadMessages(ctx context.Context, roomID string, limit int) (messages []*Message, lastNumber int, err error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	messages, err = s.queryMessages(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE room_id = ? ORDER BY number DESC LIMIT ?`,
+		roomID, limit,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `SELECT last_message_number FROM rooms WHERE id = ?`, roomID).Scan(&lastNumber)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		lastNumber = -1
+	case err != nil:
+		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return messages, lastNumber, nil
+}
+
+func (s *SQLiteStorage) LoadMessageRange(ctx context.Context, roomID string, lo, hi int) ([]*Message, error) {
+	messages, err := s.queryMessages(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE room_id = ? AND number BETWEEN ? AND ? ORDER BY number`,
+		roomID, lo, hi,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (s *SQLiteStorage) MessageBounds(ctx context.Context, roomID string) (firstNumber, lastNumber int, err error) {
+	var first, last sql.NullInt64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT MIN(number), MAX(number) FROM messages WHERE room_id = ?`, roomID,
+	).Scan(&first, &last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load message bounds: %w", err)
+	}
+
+	if !first.Valid || !last.Valid {
+		return 0, -1, nil
+	}
+
+	return int(first.Int64), int(last.Int64), nil
+}
+
+func (s *SQLiteStorage) TrimMessages(ctx context.Context, roomID string, maxMessages int, createdBefore time.Time) (int, error) {
+	var removed int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if maxMessages > 0 {
+			res, err := tx.ExecContext(ctx,
+				`DELETE FROM messages WHERE room_id = ? AND number <= (SELECT MAX(number) FROM messages WHERE room_id = ?) - ?`,
+				roomID, roomID, maxMessages,
+			)
+			if err != nil {
+				return err
+			}
+
+			n, _ := res.RowsAffected()
+			removed += n
+		}
+
+		if !createdBefore.IsZero() {
+			res, err := tx.ExecContext(ctx,
+				`DELETE FROM messages WHERE room_id = ? AND created_at < ?`,
+				roomID, createdBefore.UnixNano(),
+			)
+			if err != nil {
+				return err
+			}
+
+			n, _ := res.RowsAffected()
+			removed += n
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim messages: %w", err)
+	}
+
+	return int(removed), nil
+}
+
+func (s *SQLiteStorage) SetPresence(_ context.Context, roomID, instanceID string, userIDs []string, ttl time.Duration) error {
+	return s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		return s.presence.set(roomID, instanceID, userIDs, ttl), nil
+	})
+}
+
+func (s *SQLiteStorage) ListPresence(_ context.Context, roomID string) ([]string, error) {
+	return s.presence.list(roomID), nil
+}
+
+func (s *SQLiteStorage) PublishTyping(_ context.Context, roomID string, update *TypingUpdate) error {
+	return s.events.publishAfter(roomID, func() (*RoomEvent, error) {
+		return &RoomEvent{Type: RoomEventTyping, Typing: update}, nil
+	})
+}
+
+func (s *SQLiteStorage) AcquireLease(_ context.Context, name string, ttl time.Duration) (bool, error) {
+	return s.leases.acquire(name, ttl), nil
+}
+
+func (s *SQLiteStorage) SubscribeRoomEvents(_ context.Context, roomID string) (Subscription, error) {
+	return s.events.subscribe(roomID), nil
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) migrate(ctx context.Context) error {
+	var version int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than the supported %d", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+				return err
+			}
+
+			// PRAGMA does not accept bound parameters.
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, i+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) loadRoom(ctx context.Context, tx *sql.Tx, roomID string) (*Room, error) {
+	info, err := scanRoomInfo(tx.QueryRowContext(ctx, `SELECT `+sqliteRoomInfoColumns+` FROM rooms WHERE id = ?`, roomID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrRoomNotFound
+	case err != nil:
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	return info.Room, nil
+}
+
+// loadMember returns nil when the user is not a member, db is either the database or a transaction.
+func (s *SQLiteStorage) loadMember(ctx context.Context, db sqliteQueryRower, roomID, userID string) (*Member, error) {
+	var joinedAt int64
+	member := &Member{RoomID: roomID, UserID: userID}
+	err := db.QueryRowContext(ctx,
+		`SELECT joined_at, role FROM members WHERE room_id = ? AND user_id = ?`, roomID, userID,
+	).Scan(&joinedAt, &member.Role)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	member.JoinedAt = time.Unix(0, joinedAt)
+	return member, nil
+}
+
+// loadMessage returns ErrMessageNotFound for unknown messages and ErrMessageDeleted for tombstones,
+// db is either the database or a transaction.
+func (s *SQLiteStorage) loadMessage(ctx context.Context, db sqliteQuerier, roomID string, number int) (*Message, error) {
+	message, err := scanMessage(db.QueryRowContext(ctx,
+		`SELECT `+sqliteMessageColumns+` FROM messages WHERE room_id = ? AND number = ?`, roomID, number,
+	))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrMessageNotFound
+	case err != nil:
+		return nil, err
+	case message.Deleted():
+		return nil, ErrMessageDeleted
+	}
+
+	if err = s.loadReactions(ctx, db, []*Message{message}); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// queryMessages returns the messages selected by the query with their reactions.
+func (s *SQLiteStorage) queryMessages(ctx context.Context, query string, args ...any) ([]*Message, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*Message
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	// The rows are closed before reactions are queried, the database has a single connection.
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		return nil, err
+	}
+
+	if err = s.loadReactions(ctx, s.db, messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// loadReactions sets the reactions of messages of the same room.
+func (s *SQLiteStorage) loadReactions(ctx context.Context, db sqliteQuerier, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	lo, hi := messages[0].Number, messages[0].Number
+	for _, message := range messages {
+		lo, hi = min(lo, message.Number), max(hi, message.Number)
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT number, emoji, user_id FROM reactions WHERE room_id = ? AND number BETWEEN ? AND ?`,
+		messages[0].RoomID, lo, hi,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]*Reaction)
+	for rows.Next() {
+		var number int
+		var emoji, userID string
+		if err = rows.Scan(&number, &emoji, &userID); err != nil {
+			return err
+		}
+
+		reactions[number] = withReaction(reactions[number], emoji, userID, true)
+	}
+
+	for _, message := range messages {
+		message.Reactions = reactions[message.Number]
+	}
+
+	return rows.Err()
+}
+
+const (
+	sqliteRoomInfoColumns = `id, owner_id, name, created_at, archived, private, max_messages, max_retention, last_message_number,
+		(SELECT COUNT(*) FROM messages WHERE messages.room_id = rooms.id)`
+	sqliteMessageColumns = `number, room_id, user_id, text, created_at, reply_to, reply_count, edited_at, deleted_at, deleted_by`
+)
+
+// loadInvite returns nil for unknown invites, db is either the database or a transaction.
+func (s *SQLiteStorage) loadInvite(ctx context.Context, db sqliteQueryRower, code string) (*Invite, error) {
+	var createdAt, expiresAt int64
+	invite := &Invite{Code: code}
+	err := db.QueryRowContext(ctx,
+		`SELECT room_id, created_by, created_at, expires_at, max_uses, uses FROM invites WHERE code = ?`, code,
+	).Scan(&invite.RoomID, &invite.CreatedBy, &createdAt, &expiresAt, &invite.MaxUses, &invite.Uses)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	invite.CreatedAt = time.Unix(0, createdAt)
+	invite.ExpiresAt = time.Unix(0, expiresAt)
+	return invite, nil
+}
+
+type sqliteQueryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqliteQuerier is either the database or a transaction.
+type sqliteQuerier interface {
+	sqliteQueryRower
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// scanMessage scans sqliteMessageColumns.
+func scanMessage(row interface{ Scan(dest ...any) error }) (*Message, error) {
+	var createdAt, editedAt, deletedAt int64
+	var replyTo sql.NullInt64
+	message := &Message{}
+	err := row.Scan(&message.Number, &message.RoomID, &message.UserID, &message.Text, &createdAt, &replyTo, &message.ReplyCount,
+		&editedAt, &deletedAt, &message.DeletedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	message.CreatedAt = time.Unix(0, createdAt)
+	if replyTo.Valid {
+		number := int(replyTo.Int64)
+		message.ReplyTo = &number
+	}
+	if editedAt > 0 {
+		message.EditedAt = time.Unix(0, editedAt)
+	}
+	if deletedAt > 0 {
+		message.DeletedAt = time.Unix(0, deletedAt)
+	}
+
+	return message, nil
+}
+
+// scanRoomInfo scans sqliteRoomInfoColumns followed by extra columns.
+func scanRoomInfo(row interface{ Scan(dest ...any) error }, extra ...any) (*RoomInfo, error) {
+	var createdAt int64
+	info := &RoomInfo{Room: &Room{}}
+
+	dest := append([]any{
+		&info.ID, &info.OwnerID, &info.Name, &createdAt, &info.Archived, &info.Private, &info.MaxMessages, &info.MaxRetention,
+		&info.LastMessageNumber, &info.MessageCount,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	info.CreatedAt = time.Unix(0, createdAt)
+
+	return info, nil
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"sort"
@@ -10,124 +9,409 @@ import (
 	"time"
 
 	"github.com/DavidMovas/chat-rooms/internal/config"
+	"github.com/DavidMovas/chat-rooms/internal/metrics"
 	"github.com/google/uuid"
+)
 
-	"github.com/redis/go-redis/v9"
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
 )
 
+// Store applies the chat rules on top of the Storage and keeps the room hubs loaded by this instance.
 type Store struct {
-	rdb *redis.Client
+	storage Storage
 
 	maxMessages  int
 	maxRetention time.Duration
+	delivery     deliveryOptions
+
+	maxSessionsPerUser int
+	inviteTTL          time.Duration
+	typingTimeout      time.Duration
+	typingThrottle     time.Duration
+	presenceTTL        time.Duration
 
 	roomHub   map[string]*RoomHub
 	roomHubMx sync.RWMutex
+
+	instanceID string
 }
 
-func NewStorage(rdb *redis.Client, cfg *config.Config) *Store {
+func NewStore(storage Storage, cfg *config.Config) (*Store, error) {
+	delivery, err := newDeliveryOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delivery options: %w", err)
+	}
+
+	typingTimeout, typingThrottle := cfg.TypingTimeout, cfg.TypingThrottle
+	if typingTimeout <= 0 {
+		typingTimeout = defaultTypingTimeout
+	}
+	if typingThrottle < 0 {
+		typingThrottle = defaultTypingThrottle
+	}
+
+	presenceTTL := cfg.PresenceTTL
+	if presenceTTL <= 0 {
+		presenceTTL = defaultPresenceTTL
+	}
+
 	return &Store{
-		rdb:          rdb,
+		storage:      storage,
 		roomHub:      make(map[string]*RoomHub),
 		maxMessages:  cfg.MaxMessages,
 		maxRetention: cfg.MaxRetention,
-	}
+		delivery:     delivery,
+
+		maxSessionsPerUser: cfg.MaxSessionsPerUser,
+		inviteTTL:          cfg.InviteTTL,
+		typingTimeout:      typingTimeout,
+		typingThrottle:     typingThrottle,
+		presenceTTL:        presenceTTL,
+		instanceID:         uuid.New().String(),
+	}, nil
 }
 
-func (s *Store) CreateRoom(ctx context.Context, userID string, name string) (*Room, error) {
+func (s *Store) CreateRoom(ctx context.Context, userID string, name string, private bool) (*Room, error) {
 	room := &Room{
-		ID:      s.roomKey(),
-		OwnerID: userID,
-		Name:    name,
+		ID:        s.roomKey(),
+		OwnerID:   userID,
+		Name:      name,
+		CreatedAt: time.Now(),
+		Private:   private,
 	}
 
-	bytes, err := json.Marshal(room)
+	if err := s.storage.CreateRoom(ctx, room); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// GetRoom returns the room, only members can get a private room.
+func (s *Store) GetRoom(ctx context.Context, userID, roomID string) (*RoomInfo, error) {
+	info, err := s.getRoom(ctx, roomID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal room: %w", err)
+		return nil, err
+	}
+
+	if err = s.checkAccess(ctx, info.Room, userID); err != nil {
+		return nil, err
 	}
 
-	err = s.rdb.Set(ctx, room.ID, string(bytes), 0).Err()
+	return info, nil
+}
+
+func (s *Store) getRoom(ctx context.Context, roomID string) (*RoomInfo, error) {
+	rooms, err := s.storage.GetRooms(ctx, []string{roomID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create room: %w", err)
+		return nil, err
 	}
 
-	return room, nil
+	if len(rooms) == 0 || rooms[0] == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	return rooms[0], nil
 }
 
-func (s *Store) GetRoomHub(ctx context.Context, roomID string) (*RoomHub, error) {
-	res, err := s.rdb.Get(ctx, roomID).Result()
+// ListRooms returns rooms ordered by name, private rooms are listed only to their members.
+func (s *Store) ListRooms(ctx context.Context, userID string, filter RoomFilter, pageSize int, pageToken string) (rooms []*RoomInfo, nextPageToken string, err error) {
+	filter.VisibleTo = userID
+
+	switch {
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	return s.storage.ListRooms(ctx, filter, pageSize, pageToken)
+}
+
+// UpdateRoom applies the set fields of update, renaming and changing the settings need separate permissions.
+func (s *Store) UpdateRoom(ctx context.Context, userID, roomID string, update RoomUpdate) (*RoomInfo, error) {
+	var permissions Permission
+	if update.Name != nil {
+		permissions |= PermissionRename
+	}
+	if update.MaxMessages != nil || update.MaxRetention != nil || update.Private != nil || permissions == 0 {
+		permissions |= PermissionChangeSettings
+	}
+
+	_, err := s.updateRoom(ctx, userID, roomID, permissions, func(room *Room) {
+		if update.Name != nil {
+			room.Name = *update.Name
+		}
+		if update.MaxMessages != nil {
+			room.MaxMessages = *update.MaxMessages
+		}
+		if update.MaxRetention != nil {
+			room.MaxRetention = *update.MaxRetention
+		}
+		if update.Private != nil {
+			room.Private = *update.Private
+		}
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get room: %w", err)
+		return nil, err
 	}
 
-	var room *Room
-	if err = json.Unmarshal([]byte(res), &room); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal room: %w", err)
+	return s.getRoom(ctx, roomID)
+}
+
+func (s *Store) ArchiveRoom(ctx context.Context, userID, roomID string) (*RoomInfo, error) {
+	_, err := s.updateRoom(ctx, userID, roomID, PermissionChangeSettings, func(room *Room) {
+		room.Archived = true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return s.getOrCreateRoomHub(ctx, room)
+	return s.getRoom(ctx, roomID)
 }
 
-func (s *Store) LoadMessages(ctx context.Context, roomID string) (messages []*Message, lastNumber int, err error) {
-	tx := s.rdb.TxPipeline()
+// DeleteRoom removes the room with all its messages, only the owner can delete it.
+func (s *Store) DeleteRoom(ctx context.Context, userID, roomID string) error {
+	return s.storage.DeleteRoom(ctx, roomID, func(room *Room) error {
+		if room.OwnerID != userID {
+			return ErrPermissionDenied
+		}
 
-	getMessagesCmd := tx.ZRevRangeByScore(ctx, s.roomMessagesKey(roomID), &redis.ZRangeBy{
-		Count: int64(s.maxMessages),
-		Min:   fmt.Sprintf("%d", time.Now().Add(-s.maxRetention).UnixNano()),
-		Max:   "+inf",
+		return nil
 	})
+}
+
+func (s *Store) GetRoomHub(ctx context.Context, roomID string) (*RoomHub, error) {
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
 
-	getMessagesNumberCmd := tx.Get(ctx, s.messageNumberKey(roomID))
+	return s.getOrCreateRoomHub(ctx, room)
+}
 
-	if _, err = tx.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
-		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+// ConnectRoom uses the read cursor without lastReadMessageNumber and reloads a hub evicted before connecting.
+func (s *Store) ConnectRoom(ctx context.Context, roomID, userID string, lastReadMessageNumber *int64) (*RoomHub, *Connection, error) {
+	// Users without access are turned away before they load the hub or take a session.
+	room, err := s.loadRoom(ctx, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, nil, err
+	}
+
+	lastRead, err := s.lastReadMessageNumber(ctx, roomID, userID, lastReadMessageNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		hub, err := s.GetRoomHub(ctx, roomID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		connection, err := hub.Connect(ctx, userID, lastRead)
+		if errors.Is(err, errHubEvicted) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Access is checked again once the connection is registered, a member removed or given
+		// another role afterward is updated by the hub when the member event arrives.
+		role, err := s.accessRole(ctx, connection.room, userID)
+		if err != nil {
+			connection.Disconnect()
+			return nil, nil, err
+		}
+
+		connection.authorize(role)
+
+		return hub, connection, nil
 	}
+}
+
+func (s *Store) LoadMessages(ctx context.Context, roomID string) (messages []*Message, lastNumber int, err error) {
+	messages, lastNumber, err = s.storage.LoadMessages(ctx, roomID, s.maxMessages)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return s.retained(messages), lastNumber, nil
+}
 
-	err = getMessagesCmd.ScanSlice(&messages)
+// GetHistory returns a page of room messages ordered by number, only members can read private rooms.
+func (s *Store) GetHistory(ctx context.Context, userID, roomID string, query HistoryQuery) (messages []*Message, hasMore bool, err error) {
+	room, err := s.loadRoom(ctx, roomID)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+		return nil, false, err
 	}
 
-	lastNumber, err = getMessagesNumberCmd.Int()
+	if err = s.checkAccess(ctx, room, userID); err != nil {
+		return nil, false, err
+	}
+
+	limit := query.Limit
 	switch {
-	case errors.Is(err, redis.Nil):
-		lastNumber = -1
-	case err != nil:
-		return nil, 0, fmt.Errorf("failed to load messages: %w", err)
+	case limit <= 0:
+		limit = defaultPageSize
+	case limit > maxPageSize:
+		limit = maxPageSize
+	}
+
+	firstNumber, lastNumber, err := s.storage.MessageBounds(ctx, roomID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	lo, hi := firstNumber, lastNumber
+	if query.After != nil {
+		lo = max(lo, *query.After+1)
+	}
+	if query.Before != nil {
+		hi = min(hi, *query.Before-1)
+	}
+
+	if lo > hi {
+		return nil, false, nil
+	}
+
+	if hi-lo+1 > limit {
+		hasMore = true
+		if query.After != nil {
+			hi = lo + limit - 1
+		} else {
+			lo = hi - limit + 1
+		}
+	}
+
+	messages, err = s.loadMessageRange(ctx, roomID, lo, hi)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return messages, hasMore, nil
+}
+
+// LoadMessageRange returns retained messages numbered from from to to inclusive, ordered by number.
+func (s *Store) LoadMessageRange(ctx context.Context, roomID string, from, to int) ([]*Message, error) {
+	firstNumber, lastNumber, err := s.storage.MessageBounds(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	lo, hi := max(from, firstNumber), min(to, lastNumber)
+	if s.maxMessages > 0 {
+		lo = max(lo, lastNumber-s.maxMessages+1)
+	}
+
+	if lo > hi {
+		return nil, nil
 	}
 
-	sort.Slice(messages, func(i, j int) bool {
-		return messages[i].Number < messages[j].Number
+	return s.loadMessageRange(ctx, roomID, lo, hi)
+}
+
+func (s *Store) loadMessageRange(ctx context.Context, roomID string, lo, hi int) ([]*Message, error) {
+	messages, err := s.storage.LoadMessageRange(ctx, roomID, lo, hi)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.retained(messages), nil
+}
+
+func (s *Store) retained(messages []*Message) []*Message {
+	var minCreatedAt time.Time
+	if s.maxRetention > 0 {
+		minCreatedAt = time.Now().Add(-s.maxRetention)
+	}
+
+	retained := make([]*Message, 0, len(messages))
+	for _, m := range messages {
+		if m.CreatedAt.After(minCreatedAt) {
+			retained = append(retained, m)
+		}
+	}
+
+	sort.Slice(retained, func(i, j int) bool {
+		return retained[i].Number < retained[j].Number
 	})
 
-	return messages, lastNumber, nil
+	return retained
 }
 
 func (s *Store) SaveMessage(ctx context.Context, message *Message) error {
-	tx := s.rdb.TxPipeline()
+	return s.storage.SaveMessage(ctx, message)
+}
 
-	addMessageCmd := tx.ZAdd(ctx, s.roomMessagesKey(message.RoomID), redis.Z{
-		Score:  float64(message.CreatedAt.UnixNano()),
-		Member: message,
-	})
+// subscribeRoomEvents subscribes to the room events, no event published after it returns is missed.
+func (s *Store) subscribeRoomEvents(ctx context.Context, roomID string) (Subscription, error) {
+	return s.storage.SubscribeRoomEvents(ctx, roomID)
+}
+
+func (s *Store) updateRoom(ctx context.Context, userID, roomID string, permissions Permission, update func(room *Room)) (*Room, error) {
+	member, err := s.storage.GetMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.UpdateRoom(ctx, roomID, func(room *Room) error {
+		if !roomRole(room, userID, member).can(permissions) {
+			return ErrPermissionDenied
+		}
 
-	updateNumberCmd := tx.Set(ctx, s.messageNumberKey(message.RoomID), message.Number, 0)
+		update(room)
+		return nil
+	})
+}
 
-	if _, err := tx.Exec(ctx); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+func (s *Store) unloadRoomHub(hub *RoomHub, reason error) {
+	s.roomHubMx.Lock()
+	if s.roomHub[hub.roomID] == hub {
+		delete(s.roomHub, hub.roomID)
+		metrics.LoadedHubs.Add(-1)
 	}
+	s.roomHubMx.Unlock()
+
+	hub.close(reason)
+}
 
-	if err := addMessageCmd.Err(); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+// Close returns once users of all loaded hubs are taken offline, so the storage can be closed afterward.
+func (s *Store) Close() error {
+	s.roomHubMx.Lock()
+	hubs := s.roomHub
+	s.roomHub = make(map[string]*RoomHub)
+	s.roomHubMx.Unlock()
+
+	metrics.LoadedHubs.Add(-int64(len(hubs)))
+
+	for _, hub := range hubs {
+		hub.close(ErrServerStopped)
 	}
 
-	if err := updateNumberCmd.Err(); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+	for _, hub := range hubs {
+		<-hub.presenceDone
 	}
 
 	return nil
 }
 
+func (s *Store) loadRoom(ctx context.Context, roomID string) (*Room, error) {
+	info, err := s.getRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return info.Room, nil
+}
+
 func (s *Store) getOrCreateRoomHub(ctx context.Context, room *Room) (*RoomHub, error) {
 	s.roomHubMx.RLock()
 	hub := s.roomHub[room.ID]
@@ -151,6 +435,7 @@ func (s *Store) getOrCreateRoomHub(ctx context.Context, room *Room) (*RoomHub, e
 	}
 
 	s.roomHub[room.ID] = hub
+	metrics.LoadedHubs.Add(1)
 
 	return hub, nil
 }
@@ -159,16 +444,53 @@ func (s *Store) roomKey() string {
 	return fmt.Sprintf("rooms:%s:data", uuid.New().String())
 }
 
-func (s *Store) roomMessagesKey(roomID string) string {
-	return fmt.Sprintf("%s:messages", roomID)
+type Room struct {
+	ID        string
+	OwnerID   string
+	Name      string
+	CreatedAt time.Time
+	Archived  bool
+	// Private rooms can only be connected to by their members.
+	Private bool
+
+	// MaxMessages and MaxRetention override the server retention limits when they are stricter,
+	// zero values mean no override.
+	MaxMessages  int
+	MaxRetention time.Duration
 }
 
-func (s *Store) messageNumberKey(roomID string) string {
-	return fmt.Sprintf("%s:last_message_number", roomID)
+type RoomInfo struct {
+	*Room
+	MessageCount      int64
+	LastMessageNumber int
 }
 
-type Room struct {
-	ID      string
-	OwnerID string
-	Name    string
+// RoomUpdate holds the room fields to change, nil fields are kept.
+type RoomUpdate struct {
+	Name         *string
+	MaxMessages  *int
+	MaxRetention *time.Duration
+	Private      *bool
+}
+
+// Member is a user who joined the room, the owner becomes a member when the room is created.
+type Member struct {
+	RoomID   string
+	UserID   string
+	JoinedAt time.Time
+	// Role is empty for members stored before roles were introduced, they have RoleMember.
+	Role Role
+}
+
+type HistoryQuery struct {
+	Before *int
+	After  *int
+	Limit  int
+}
+
+type RoomFilter struct {
+	OwnerID    string
+	NamePrefix string
+	// VisibleTo skips private rooms the user is not a member of, internal listings leave it empty.
+	VisibleTo string
 }
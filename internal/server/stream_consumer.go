@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamConsumer reads room messages as a member of a consumer group, so downstream processors
+// can share the work and resume after a restart. A message is delivered to a single consumer
+// of the group and stays pending until it is acknowledged.
+type StreamConsumer struct {
+	rdb      *redis.Client
+	key      string
+	group    string
+	consumer string
+}
+
+// NewStreamConsumer creates the consumer group of the room, if it does not exist yet, starting
+// after lastNumber. A negative lastNumber makes the group read the room from the beginning.
+func (s *RedisStorage) NewStreamConsumer(ctx context.Context, roomID, group, consumer string, lastNumber int) (*StreamConsumer, error) {
+	log, ok := s.log.(streamLog)
+	if !ok {
+		return nil, ErrNotStreamLog
+	}
+
+	start := "0"
+	if lastNumber >= 0 {
+		start = streamEntryID(lastNumber)
+	}
+
+	key := log.key(roomID)
+	err := s.rdb.XGroupCreateMkStream(ctx, key, group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	return &StreamConsumer{
+		rdb:      s.rdb,
+		key:      key,
+		group:    group,
+		consumer: consumer,
+	}, nil
+}
+
+// Read returns up to count messages never delivered to the group, waiting up to block for them.
+// A zero block waits forever, a negative one does not wait. No messages and no error are returned
+// when nothing arrives in time.
+func (c *StreamConsumer) Read(ctx context.Context, count int, block time.Duration) ([]*Message, error) {
+	return c.read(ctx, ">", count, block)
+}
+
+// Pending returns up to count messages delivered to this consumer but not acknowledged yet,
+// a processor reads them after a restart before reading new ones.
+func (c *StreamConsumer) Pending(ctx context.Context, count int) ([]*Message, error) {
+	return c.read(ctx, "0", count, -1)
+}
+
+// Ack marks the messages as processed by the group.
+func (c *StreamConsumer) Ack(ctx context.Context, numbers ...int) error {
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(numbers))
+	for i, number := range numbers {
+		ids[i] = streamEntryID(number)
+	}
+
+	if err := c.rdb.XAck(ctx, c.key, c.group, ids...).Err(); err != nil {
+		return fmt.Errorf("failed to ack messages: %w", err)
+	}
+
+	return nil
+}
+
+func (c *StreamConsumer) read(ctx context.Context, id string, count int, block time.Duration) ([]*Message, error) {
+	streams, err := c.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.consumer,
+		Streams:  []string{c.key, id},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+
+	var messages []*Message
+	for _, stream := range streams {
+		// Pending entries trimmed from the stream are returned without values.
+		for _, entry := range stream.Messages {
+			if len(entry.Values) == 0 {
+				continue
+			}
+
+			message, err := streamMessage(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			messages = append(messages, message)
+		}
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultTypingTimeout  = 5 * time.Second
+	defaultTypingThrottle = 2 * time.Second
+	// typingQueueSize bounds the typing updates waiting to be published, further updates are dropped.
+	typingQueueSize = 64
+	// typingPublishTimeout bounds publishing a single typing update.
+	typingPublishTimeout = 5 * time.Second
+)
+
+// TypingUpdate tells connections that a user started or stopped typing.
+type TypingUpdate struct {
+	UserID string
+	Typing bool
+	// ExpiresAt is when receivers stop a started indicator unless it is started again, so it stops even
+	// when the instance of the typing user never publishes the stop.
+	ExpiresAt time.Time
+}
+
+// typingState is the indicator of a user typing in the room.
+type typingState struct {
+	// timer stops the indicator once it expires, it is rescheduled when expiresAt has moved on.
+	timer     *time.Timer
+	expiresAt time.Time
+	// sentAt is when the indicator was last broadcast.
+	sentAt time.Time
+}
+
+// Typing starts or stops the typing indicator of the user. The indicator stops on its own after the
+// typing timeout, the user sends a message or the last connection of the user leaves. Repeated signals
+// extend the indicator, but are broadcast at most once per typing throttle. Indicators are published to
+// the room events channel, so other users connected through any server instance receive them, and are
+// never stored.
+func (h *RoomHub) Typing(userID string, typing bool) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	state := h.typing[userID]
+	if !typing {
+		if state != nil {
+			h.stopTyping(userID)
+		}
+		return
+	}
+
+	now := time.Now()
+	if state == nil {
+		state = &typingState{}
+		state.timer = time.AfterFunc(h.store.typingTimeout, func() {
+			h.expireTyping(userID, state)
+		})
+		h.typing[userID] = state
+	}
+
+	state.expiresAt = now.Add(h.store.typingTimeout)
+	if now.Sub(state.sentAt) < h.store.typingThrottle {
+		return
+	}
+
+	state.sentAt = now
+	h.queueTyping(&TypingUpdate{UserID: userID, Typing: true, ExpiresAt: state.expiresAt})
+}
+
+// expireTyping stops the indicator unless it has been extended since the timer was scheduled.
+func (h *RoomHub) expireTyping(userID string, state *typingState) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed || h.typing[userID] != state {
+		return
+	}
+
+	if remaining := time.Until(state.expiresAt); remaining > 0 {
+		state.timer.Reset(remaining)
+		return
+	}
+
+	h.stopTyping(userID)
+}
+
+// stopTyping must be called with h.mx held for a user who is typing.
+func (h *RoomHub) stopTyping(userID string) {
+	h.typing[userID].timer.Stop()
+	delete(h.typing, userID)
+	h.queueTyping(&TypingUpdate{UserID: userID, Typing: false})
+}
+
+// queueTyping hands the update over to forwardTyping, it must be called with h.mx held. Updates are
+// advisory, so they are dropped when the storage can not keep up.
+func (h *RoomHub) queueTyping(update *TypingUpdate) {
+	select {
+	case h.typingUpdates <- update:
+	default:
+		slog.Warn("typing update dropped", "room_id", h.roomID, "user_id", update.UserID)
+	}
+}
+
+// forwardTyping publishes the queued typing updates in order until the hub is closed. Publishing waits
+// for the storage, so it is done without holding h.mx.
+func (h *RoomHub) forwardTyping() {
+	for update := range h.typingUpdates {
+		ctx, cancel := context.WithTimeout(context.Background(), typingPublishTimeout)
+		if err := h.store.storage.PublishTyping(ctx, h.roomID, update); err != nil {
+			slog.Error("failed to publish typing", "room_id", h.roomID, "user_id", update.UserID, "error", err)
+		}
+		cancel()
+	}
+}
+
+// applyTyping delivers typing updates published by any server instance to connections of other users.
+// The updates are advisory, connections that fall behind miss them. Shown indicators stop at their expiry,
+// a stop received afterward is not delivered again.
+func (h *RoomHub) applyTyping(update *TypingUpdate) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	state := h.shownTyping[update.UserID]
+	if state != nil {
+		state.timer.Stop()
+		delete(h.shownTyping, update.UserID)
+	} else if !update.Typing {
+		return
+	}
+
+	if update.Typing {
+		state = &typingState{expiresAt: update.ExpiresAt}
+		state.timer = time.AfterFunc(time.Until(update.ExpiresAt), func() {
+			h.hideTyping(update.UserID, state)
+		})
+		h.shownTyping[update.UserID] = state
+	}
+
+	h.offerTyping(update)
+}
+
+// hideTyping stops a shown indicator that has expired before its stop was received.
+func (h *RoomHub) hideTyping(userID string, state *typingState) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.closed || h.shownTyping[userID] != state {
+		return
+	}
+
+	delete(h.shownTyping, userID)
+	h.offerTyping(&TypingUpdate{UserID: userID, Typing: false})
+}
+
+// offerTyping must be called with h.mx held.
+func (h *RoomHub) offerTyping(update *TypingUpdate) {
+	event := &Event{Typing: update}
+	for _, connection := range h.connections {
+		if connection.UserID != update.UserID {
+			h.offer(connection, event)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewServerConfig returns a TLS config serving the certificate of certFile and keyFile. When clientCAFile
+// is set clients must present a certificate signed by one of its CAs (mutual TLS). The files are reloaded
+// on the next handshake after any of them changes, so certificates can be rotated without a restart.
+func NewServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	r := &reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if _, err := r.config(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config()
+		},
+	}, nil
+}
+
+// NewClientConfig returns a TLS config trusting the CAs of caFile, or the system ones when it is empty.
+// certFile and keyFile are the client certificate for mutual TLS, they are optional.
+func NewClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// reloader builds the server TLS config from files and rebuilds it when their modification time or size changes.
+type reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mx      sync.Mutex
+	stamps  map[string]fileStamp
+	current *tls.Config
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// config returns the current config. If reloading changed files fails, the previous config is kept.
+func (r *reloader) config() (*tls.Config, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	stamps, err := r.stat()
+	if err == nil && r.current != nil && sameStamps(stamps, r.stamps) {
+		return r.current, nil
+	}
+
+	if err == nil {
+		var cfg *tls.Config
+		if cfg, err = r.load(); err == nil {
+			if r.current != nil {
+				slog.Info("tls certificates reloaded", "cert_file", r.certFile)
+			}
+
+			r.current, r.stamps = cfg, stamps
+			return cfg, nil
+		}
+	}
+
+	if r.current == nil {
+		return nil, err
+	}
+
+	slog.Error("failed to reload tls certificates, keeping the previous ones", "error", err)
+	r.stamps = stamps
+	return r.current, nil
+}
+
+func (r *reloader) stat() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp, 3)
+	for _, name := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if name == "" {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		stamps[name] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	return stamps, nil
+}
+
+func (r *reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.clientCAFile != "" {
+		pool, err := loadCertPool(r.clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, stamp := range a {
+		if other, ok := b[name]; !ok || !stamp.modTime.Equal(other.modTime) || stamp.size != other.size {
+			return false
+		}
+	}
+
+	return true
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in ca bundle %s", caFile)
+	}
+
+	return pool, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -18,16 +19,10 @@ func main() {
 	cfg, err := config.NewConfig()
 	failOrError(err, "failed to load config")
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr: cfg.RedisURL,
-	})
+	storage, err := newStorage(cfg)
+	failOrError(err, "failed to create storage")
 
-	if cmd := rdb.Ping(shortContext()); cmd.Err() != nil {
-		slog.Error("failed to connect to redis", "error", cmd.Err())
-		os.Exit(1)
-	}
-
-	srv, err := server.NewServer(cfg, rdb)
+	srv, err := server.NewServer(cfg, storage)
 	failOrError(err, "failed to create server")
 
 	go func() {
@@ -49,6 +44,34 @@ func main() {
 	}
 }
 
+func newStorage(cfg *config.Config) (server.Storage, error) {
+	switch cfg.StorageDriver {
+	case "redis":
+		rdb := redis.NewClient(&redis.Options{
+			Addr: cfg.RedisURL,
+		})
+
+		if cmd := rdb.Ping(shortContext()); cmd.Err() != nil {
+			_ = rdb.Close()
+			return nil, fmt.Errorf("failed to connect to redis: %w", cmd.Err())
+		}
+
+		storage, err := server.NewRedisStorage(rdb, cfg)
+		if err != nil {
+			_ = rdb.Close()
+			return nil, err
+		}
+
+		return storage, nil
+	case "sqlite":
+		return server.NewSQLiteStorage(shortContext(), cfg.SQLitePath)
+	case "memory":
+		return server.NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
+
 func failOrError(err error, msg string) {
 	if err != nil {
 		slog.Error(msg, "error", err)